@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// show the wait indicator only if reply doesn't come quickly
+const waitIndicatorDelay = 500 * time.Millisecond
+
+// timeout of commands which block until data arrives, 0 means forever,
+// returns false if command doesn't block
+func blockTimeout(argv []string) (time.Duration, bool) {
+	if len(argv) == 0 {
+		return 0, false
+	}
+	last := argv[len(argv)-1]
+	switch strings.ToLower(argv[0]) {
+	case "blpop", "brpop", "bzpopmin", "bzpopmax", "brpoplpush", "blmove":
+		return parseTimeout(last, time.Second), len(argv) > 2
+	case "blmpop", "bzmpop":
+		// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT
+		if len(argv) > 1 {
+			return parseTimeout(argv[1], time.Second), true
+		}
+	case "wait", "waitaof":
+		return parseTimeout(last, time.Millisecond), len(argv) > 2
+	case "xread", "xreadgroup":
+		for i := 1; i+1 < len(argv); i++ {
+			switch strings.ToLower(argv[i]) {
+			case "group":
+				// group and consumer names may be anything, even "block"
+				i += 2
+			case "block":
+				return parseTimeout(argv[i+1], time.Millisecond), true
+			case "streams":
+				return 0, false
+			}
+		}
+	}
+	return 0, false
+}
+
+// timeout in units, like "1.5" seconds or "500" milliseconds, invalid ones are rejected by server
+func parseTimeout(str string, unit time.Duration) time.Duration {
+	v, err := strconv.ParseFloat(str, 64)
+	if err != nil || v < 0 {
+		return 0
+	}
+	return time.Duration(v * float64(unit))
+}
+
+// reply timeout of command, blocking commands wait their own timeout in addition to --timeout
+func (c *Connection) replyTimeout(argv []string) (time.Duration, bool) {
+	timeout := seconds(c.args.Timeout)
+	block, blocking := blockTimeout(argv)
+	if !blocking {
+		return timeout, false
+	}
+	if block == 0 || timeout == 0 {
+		return 0, true
+	}
+	return block + timeout, true
+}
+
+// show time elapsed since start while waiting for reply in interactive mode, returns func to stop and clear it
+func (c *Connection) waitIndicator(start time.Time) func() {
+	if ttyState == nil || !c.istty {
+		return func() {}
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		shown := false
+		for {
+			select {
+			case <-done:
+				if shown {
+					_, _ = fmt.Fprint(c.writer, "\r\033[K")
+				}
+				return
+			case <-ticker.C:
+				if elapsed := time.Since(start); elapsed >= waitIndicatorDelay {
+					_, _ = fmt.Fprintf(c.writer, "\r(waiting %.1fs, Ctrl-C to cancel)", elapsed.Seconds())
+					shown = true
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlockTimeout(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		timeout  time.Duration
+		blocking bool
+	}{
+		{cmd: "", blocking: false},
+		{cmd: "get k", blocking: false},
+		{cmd: "BLPOP q 5", timeout: 5 * time.Second, blocking: true},
+		{cmd: "brpop q1 q2 0.5", timeout: 500 * time.Millisecond, blocking: true},
+		{cmd: "blpop q 0", timeout: 0, blocking: true},
+		{cmd: "blpop q", blocking: false},
+		{cmd: "bzpopmin z 1", timeout: time.Second, blocking: true},
+		{cmd: "blmove src dst LEFT RIGHT 2", timeout: 2 * time.Second, blocking: true},
+		{cmd: "BLMPOP 1.5 2 q1 q2 LEFT COUNT 10", timeout: 1500 * time.Millisecond, blocking: true},
+		{cmd: "bzmpop 3 1 z MIN", timeout: 3 * time.Second, blocking: true},
+		{cmd: "blmpop", blocking: false},
+		{cmd: "WAIT 1 100", timeout: 100 * time.Millisecond, blocking: true},
+		{cmd: "waitaof 1 0 250", timeout: 250 * time.Millisecond, blocking: true},
+		{cmd: "XREAD COUNT 1 BLOCK 2000 STREAMS s $", timeout: 2 * time.Second, blocking: true},
+		{cmd: "xread block 0 streams s $", timeout: 0, blocking: true},
+		{cmd: "XREAD COUNT 1 STREAMS block $", blocking: false},
+		{cmd: "XREADGROUP GROUP block consumer STREAMS s >", blocking: false},
+		{cmd: "XREADGROUP GROUP g block BLOCK 100 STREAMS s >", timeout: 100 * time.Millisecond, blocking: true},
+		{cmd: "XREADGROUP GROUP block block COUNT 1 BLOCK 50 STREAMS s >", timeout: 50 * time.Millisecond, blocking: true},
+		// invalid timeouts are rejected by server, so there's no wait
+		{cmd: "blpop q abc", timeout: 0, blocking: true},
+		{cmd: "blpop q -1", timeout: 0, blocking: true},
+	}
+	for _, tt := range tests {
+		timeout, blocking := blockTimeout(strings.Fields(tt.cmd))
+		if timeout != tt.timeout || blocking != tt.blocking {
+			t.Errorf("blockTimeout(%q) = %v, %v, want %v, %v", tt.cmd, timeout, blocking, tt.timeout, tt.blocking)
+		}
+	}
+}
+
+func TestReplyTimeout(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		timeout  float64
+		want     time.Duration
+		blocking bool
+	}{
+		{cmd: "", timeout: 2, want: 2 * time.Second},
+		{cmd: "get k", timeout: 2, want: 2 * time.Second},
+		{cmd: "get k", timeout: 0, want: 0},
+		// blocking commands wait their own timeout in addition to --timeout
+		{cmd: "blpop q 5", timeout: 2, want: 7 * time.Second, blocking: true},
+		{cmd: "blpop q 0", timeout: 2, want: 0, blocking: true},
+		{cmd: "blpop q 5", timeout: 0, want: 0, blocking: true},
+	}
+	for _, tt := range tests {
+		c := &Connection{args: &Args{Timeout: tt.timeout}}
+		got, blocking := c.replyTimeout(strings.Fields(tt.cmd))
+		if got != tt.want || blocking != tt.blocking {
+			t.Errorf("replyTimeout(%q, --timeout %v) = %v, %v, want %v, %v", tt.cmd, tt.timeout, got, blocking, tt.want, tt.blocking)
+		}
+	}
+}
+
+func TestExecArgsEmpty(t *testing.T) {
+	c := &Connection{args: &Args{}}
+	if _, err := c.ExecArgs(); err != ErrInvalidArgs {
+		t.Errorf("ExecArgs() error = %v, want ErrInvalidArgs", err)
+	}
+}
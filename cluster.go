@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const clusterSlots = 16384
+
+// follow no more redirections than this for one command
+const clusterMaxRedirects = 16
+
+// cluster mode (-c), commands are routed to the node owning the slot of its first key,
+// and MOVED / ASK redirections are followed
+type Cluster struct {
+	facade   *Connection            // connection used by callers, which delegates to cluster
+	nodes    map[string]*Connection // connections per node, by host:port
+	slots    [clusterSlots]string   // node address owning each slot
+	current  *Connection            // node serving the last command, shown in prompt
+	active   atomic.Pointer[Connection]
+	fanout   atomic.Pointer[[]*Connection] // nodes running a command of :all, interrupted together
+	commands map[string]*commandInfo       // key positions by command name, like "get" or "object|encoding"
+}
+
+// key specs of a command from COMMAND INFO
+type commandInfo struct {
+	firstKey    int
+	movable     bool // keys can't be found by position, ask server by COMMAND GETKEYS
+	subcommands bool
+}
+
+// connect to seed node and load slot map
+func (c *Connection) connectCluster() error {
+	_ = c.Close()
+	cl := &Cluster{
+		facade:   c,
+		nodes:    map[string]*Connection{},
+		commands: map[string]*commandInfo{},
+	}
+	seed := cl.newNode(c.host, c.port)
+	// seed may be reached through unix socket, other nodes are always tcp
+	seed.socket = c.socket
+	if err := seed.Connect(); err != nil {
+		return err
+	}
+	_, addr := seed.address()
+	cl.nodes[addr] = seed
+	cl.current = seed
+	c.mu.Lock()
+	c.cluster = cl
+	c.mu.Unlock()
+	c.connected = true
+	if err := cl.refresh(); err != nil {
+		_, _ = fmt.Fprintf(c.writer, "Could not load cluster slots: %s\n", err.Error())
+	}
+	return nil
+}
+
+func (cl *Cluster) newNode(host string, port int) *Connection {
+	nargs := *cl.facade.args
+	nargs.ClusterMode = false
+	nargs.Sentinel = ""
+	node := NewConnection(&nargs)
+	node.host, node.port, node.socket = host, port, ""
+	node.reconnect = cl.facade.reconnect
+	node.deadline = cl.facade.deadline
+	// share cached password, --askpass is asked only once
+	node.pass, node.passRead = cl.facade.password(), true
+	node.writer = cl.facade.writer
+	return node
+}
+
+// connection to node by address, connect it if not connected yet
+func (cl *Cluster) node(addr string) (*Connection, error) {
+	if node, ok := cl.nodes[addr]; ok {
+		return node, nil
+	}
+	host, port, err := parseHostPort(addr, 6379)
+	if err != nil {
+		return nil, err
+	}
+	node := cl.newNode(host, port)
+	if err := node.Connect(); err != nil {
+		return nil, err
+	}
+	cl.nodes[addr] = node
+	return node, nil
+}
+
+func (cl *Cluster) close() {
+	for _, node := range cl.nodes {
+		_ = node.Close()
+	}
+}
+
+// exec command on the node owning its key, and follow redirections
+func (cl *Cluster) exec(argv []string) (*TypedVal, error) {
+	node, err := cl.route(argv)
+	if err != nil {
+		return nil, err
+	}
+	asking, moved := false, false
+	for i := 0; ; i++ {
+		var tv *TypedVal
+		cl.active.Store(node)
+		if asking {
+			// ASKING only affects the next command on the same connection
+			tv, err = node.ExecArgs("ASKING")
+			if err == nil {
+				tv, err = node.ExecArgs(argv...)
+			}
+		} else {
+			tv, err = node.ExecArgs(argv...)
+		}
+		cl.active.Store(nil)
+		if err != nil {
+			return nil, err
+		}
+		kind, slot, addr := parseRedirect(tv)
+		if kind == "" || i >= clusterMaxRedirects {
+			cl.current = node
+			if moved {
+				_ = cl.refresh()
+			}
+			return tv, nil
+		}
+		if cl.facade.istty {
+			_, _ = fmt.Fprintf(cl.facade.writer, "-> Redirected to slot [%d] located at %s\n", slot, addr)
+		}
+		if kind == "MOVED" {
+			// slot map is stale, update this slot now and the whole map later
+			cl.slots[slot] = addr
+			moved = true
+		}
+		if node, err = cl.node(addr); err != nil {
+			return nil, err
+		}
+		asking = kind == "ASK"
+	}
+}
+
+// parse "MOVED 3999 127.0.0.1:6381" or "ASK 3999 127.0.0.1:6381"
+func parseRedirect(tv *TypedVal) (kind string, slot int, addr string) {
+	if !tv.IsError() {
+		return
+	}
+	fields := strings.Fields(tv.Val.(string))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return
+	}
+	slot, err := strconv.Atoi(fields[1])
+	if err != nil || slot < 0 || slot >= clusterSlots {
+		return "", 0, ""
+	}
+	return fields[0], slot, fields[2]
+}
+
+// node owning the slot of first key, or current node for keyless commands
+func (cl *Cluster) route(argv []string) (*Connection, error) {
+	key, ok := cl.firstKey(argv)
+	if !ok {
+		return cl.current, nil
+	}
+	addr := cl.slots[keyHashSlot(key)]
+	if addr == "" {
+		return cl.current, nil
+	}
+	return cl.node(addr)
+}
+
+func (cl *Cluster) firstKey(argv []string) (string, bool) {
+	name := strings.ToLower(argv[0])
+	info := cl.commandInfo(name)
+	if info == nil {
+		return "", false
+	}
+	if info.subcommands && len(argv) > 1 {
+		if sub := cl.commandInfo(name + "|" + strings.ToLower(argv[1])); sub != nil {
+			info = sub
+		}
+	}
+	if info.movable {
+		args := append([]string{"COMMAND", "GETKEYS"}, argv...)
+		tv, err := cl.current.ExecArgs(args...)
+		if err != nil || tv.IsError() {
+			return "", false
+		}
+		if keys, _ := tv.Val.([]*TypedVal); len(keys) > 0 {
+			key, ok := keys[0].Val.(string)
+			return key, ok
+		}
+		return "", false
+	}
+	if info.firstKey > 0 && info.firstKey < len(argv) {
+		return argv[info.firstKey], true
+	}
+	return "", false
+}
+
+// key positions of command, fetched by COMMAND INFO and cached
+func (cl *Cluster) commandInfo(name string) *commandInfo {
+	if info, ok := cl.commands[name]; ok {
+		return info
+	}
+	// subcommands are loaded with their container command
+	if container, _, found := strings.Cut(name, "|"); found {
+		cl.commandInfo(container)
+		return cl.commands[name]
+	}
+	cl.commands[name] = nil
+	tv, err := cl.current.ExecArgs("COMMAND", "INFO", name)
+	if err != nil || tv.IsError() {
+		return nil
+	}
+	if items, _ := tv.Val.([]*TypedVal); len(items) == 1 {
+		cl.addCommandInfo(items[0])
+	}
+	return cl.commands[name]
+}
+
+// [name, arity, flags, first key, last key, step, acl categories, tips, key specs, subcommands]
+func (cl *Cluster) addCommandInfo(tv *TypedVal) {
+	fields, _ := tv.Val.([]*TypedVal)
+	if len(fields) < 6 {
+		return
+	}
+	name, _ := fields[0].Val.(string)
+	info := &commandInfo{}
+	info.firstKey, _ = fields[3].Val.(int)
+	flags, _ := fields[2].Val.([]*TypedVal)
+	for _, flag := range flags {
+		if flag.Val == "movablekeys" {
+			info.movable = true
+		}
+	}
+	if len(fields) > 9 {
+		subcommands, _ := fields[9].Val.([]*TypedVal)
+		for _, sub := range subcommands {
+			cl.addCommandInfo(sub)
+		}
+		info.subcommands = len(subcommands) > 0
+	}
+	cl.commands[strings.ToLower(name)] = info
+}
+
+// load slot map by CLUSTER SHARDS, or CLUSTER SLOTS before redis 7
+func (cl *Cluster) refresh() error {
+	tv, err := cl.current.ExecArgs("CLUSTER", "SHARDS")
+	if err != nil {
+		return err
+	}
+	if tv.IsError() {
+		return cl.refreshBySlots()
+	}
+	var slots [clusterSlots]string
+	for _, shard := range tv.Val.([]*TypedVal) {
+		fields := shard.Pairs()
+		addr := ""
+		for _, node := range listOf(fields["nodes"]) {
+			info := node.Pairs()
+			if info["role"].String() != "master" {
+				continue
+			}
+			host := info["endpoint"].String()
+			if host == "" || host == "?" {
+				host = info["ip"].String()
+			}
+			port := info["port"].String()
+			if cl.facade.args.Tls && info["tls-port"] != nil {
+				port = info["tls-port"].String()
+			}
+			addr = cl.nodeAddr(host, port)
+		}
+		ranges := listOf(fields["slots"])
+		for i := 0; i+1 < len(ranges); i += 2 {
+			start, _ := ranges[i].Val.(int)
+			end, _ := ranges[i+1].Val.(int)
+			for slot := start; slot <= end && slot < clusterSlots; slot++ {
+				slots[slot] = addr
+			}
+		}
+	}
+	cl.slots = slots
+	return nil
+}
+
+// [[start, end, [host, port, id], [replica host, port, id]...]...]
+func (cl *Cluster) refreshBySlots() error {
+	tv, err := cl.current.ExecArgs("CLUSTER", "SLOTS")
+	if err != nil {
+		return err
+	}
+	if tv.IsError() {
+		return fmt.Errorf("%s", tv.Val)
+	}
+	var slots [clusterSlots]string
+	for _, item := range tv.Val.([]*TypedVal) {
+		fields := listOf(item)
+		if len(fields) < 3 {
+			continue
+		}
+		start, _ := fields[0].Val.(int)
+		end, _ := fields[1].Val.(int)
+		master := listOf(fields[2])
+		if len(master) < 2 {
+			continue
+		}
+		addr := cl.nodeAddr(master[0].String(), master[1].String())
+		for slot := start; slot <= end && slot < clusterSlots; slot++ {
+			slots[slot] = addr
+		}
+	}
+	cl.slots = slots
+	return nil
+}
+
+// address of node, unknown host means the node we are talking to
+func (cl *Cluster) nodeAddr(host, port string) string {
+	if host == "" || host == "?" {
+		host = cl.current.host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func listOf(tv *TypedVal) []*TypedVal {
+	if tv == nil {
+		return nil
+	}
+	items, _ := tv.Val.([]*TypedVal)
+	return items
+}
+
+// CRC16 (XMODEM) used by redis cluster
+var crc16tab = func() (tab [256]uint16) {
+	for i := range tab {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		tab[i] = crc
+	}
+	return
+}()
+
+func crc16(buf string) uint16 {
+	var crc uint16
+	for i := 0; i < len(buf); i++ {
+		crc = crc<<8 ^ crc16tab[byte(crc>>8)^buf[i]]
+	}
+	return crc
+}
+
+// hash slot of key, only the part between the first { and the following } is hashed if it's not empty
+func keyHashSlot(key string) int {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+	return int(crc16(key) & (clusterSlots - 1))
+}
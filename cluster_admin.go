@@ -0,0 +1,1205 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slot and the node it's moved from, in reshard and rebalance plans
+type slotMove struct {
+	source *clusterNode
+	slot   int
+}
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// read answer of user, empty on EOF
+func ask(question string) string {
+	fmt.Print(question)
+	line, _ := stdinReader.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+// ask user to accept the plan, --cluster-yes accepts all
+func confirm(question string) bool {
+	if clusterOpts.Yes {
+		return true
+	}
+	return ask(question) == "yes"
+}
+
+// exec command on node, error replies are returned as error
+func (n *clusterNode) call(argv ...string) (*TypedVal, error) {
+	if n.conn == nil {
+		return nil, fmt.Errorf("node %s is not connected", n.addr)
+	}
+	tv, err := n.conn.ExecArgs(argv...)
+	if err != nil {
+		return nil, err
+	}
+	if tv.IsError() {
+		return nil, fmt.Errorf("%s", tv.Val)
+	}
+	return tv, nil
+}
+
+// send command which changes the cluster, or only print it with --dry-run
+func (cv *clusterView) apply(node *clusterNode, argv ...string) error {
+	if clusterOpts.DryRun {
+		fmt.Printf("[DRY RUN] %s: %s\n", node.addr, strings.Join(argv, " "))
+		return nil
+	}
+	if _, err := node.call(argv...); err != nil {
+		return fmt.Errorf("Calling %s %s on %s: %w", argv[0], argv[1], node.addr, err)
+	}
+	return nil
+}
+
+func hasSlot(node *clusterNode, slot int) bool {
+	for _, s := range node.slots {
+		if s == slot {
+			return true
+		}
+	}
+	return false
+}
+
+func slotRange(first, last int) []int {
+	slots := make([]int, 0, last-first+1)
+	for slot := first; slot <= last; slot++ {
+		slots = append(slots, slot)
+	}
+	return slots
+}
+
+// node by id, or by unique prefix of id
+func (cv *clusterView) findNode(id string) *clusterNode {
+	if node := cv.nodeById(id); node != nil {
+		return node
+	}
+	var found *clusterNode
+	for _, node := range cv.nodes {
+		if id != "" && strings.HasPrefix(node.id, id) {
+			if found != nil {
+				return nil
+			}
+			found = node
+		}
+	}
+	return found
+}
+
+// connected master with fewest replicas, except node
+func (cv *clusterView) leastReplicatedMaster(except *clusterNode) *clusterNode {
+	var res *clusterNode
+	for _, node := range cv.masters() {
+		if node == except || node.conn == nil {
+			continue
+		}
+		if res == nil || len(node.replicas) < len(res.replicas) {
+			res = node
+		}
+	}
+	return res
+}
+
+// new node must be cluster enabled, and know no other nodes nor hold keys
+func (n *clusterNode) checkEmpty() error {
+	lines, err := n.loadSelf()
+	if err != nil {
+		return fmt.Errorf("[ERR] Node %s is not configured as a cluster node.", n.addr)
+	}
+	dbsize := 0
+	if tv, err := n.call("DBSIZE"); err == nil {
+		dbsize, _ = tv.Val.(int)
+	}
+	if len(lines) != 1 || dbsize > 0 {
+		return fmt.Errorf("[ERR] Node %s is not empty. Either the node already knows other nodes "+
+			"(check with CLUSTER NODES) or contains some key in database 0.", n.addr)
+	}
+	return nil
+}
+
+func (cv *clusterView) keysInSlot(node *clusterNode, slot int) (int, error) {
+	tv, err := node.call("CLUSTER", "COUNTKEYSINSLOT", strconv.Itoa(slot))
+	if err != nil {
+		return 0, err
+	}
+	count, _ := tv.Val.(int)
+	return count, nil
+}
+
+// assign slots by CLUSTER ADDSLOTSRANGE, or ADDSLOTS before redis 7
+func (cv *clusterView) addSlots(node *clusterNode, slots []int) error {
+	argv := []string{"CLUSTER", "ADDSLOTSRANGE"}
+	for _, r := range slotRanges(slots) {
+		argv = append(argv, strconv.Itoa(r[0]), strconv.Itoa(r[1]))
+	}
+	err := cv.apply(node, argv...)
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "unknown subcommand") {
+		argv = []string{"CLUSTER", "ADDSLOTS"}
+		for _, slot := range slots {
+			argv = append(argv, strconv.Itoa(slot))
+		}
+		err = cv.apply(node, argv...)
+	}
+	return err
+}
+
+// wait until all nodes know each other and agree about slots configuration,
+// gives up after --cluster-timeout milliseconds
+func (cv *clusterView) waitJoin() error {
+	if clusterOpts.DryRun {
+		return nil
+	}
+	fmt.Print("Waiting for the cluster to join\n")
+	deadline := time.Now().Add(time.Duration(clusterOpts.Timeout) * time.Millisecond)
+	for !cv.joined() {
+		if !time.Now().Before(deadline) {
+			fmt.Println()
+			return fmt.Errorf("[ERR] Nodes didn't join the cluster in %d ms (--cluster-timeout)", clusterOpts.Timeout)
+		}
+		fmt.Print(".")
+		time.Sleep(min(time.Second, time.Until(deadline)))
+	}
+	fmt.Println()
+	return nil
+}
+
+func (cv *clusterView) joined() bool {
+	for _, node := range cv.nodes {
+		if node.conn == nil {
+			continue
+		}
+		lines, err := node.clusterNodes()
+		if err != nil || len(lines) < len(cv.nodes) {
+			return false
+		}
+		for _, line := range lines {
+			if n := parseClusterNodeLine(line); n == nil || n.hasFlag("handshake") {
+				return false
+			}
+		}
+	}
+	return cv.configConsistent()
+}
+
+// move keys of slot from src to dst in batches of --cluster-pipeline keys
+func (cv *clusterView) migrateKeys(src, dst *clusterNode, slot int) error {
+	if clusterOpts.DryRun {
+		if count, err := cv.keysInSlot(src, slot); err == nil && count > 0 {
+			fmt.Printf("[DRY RUN] %s: MIGRATE %d keys of slot %d to %s\n", src.addr, count, slot, dst.addr)
+		}
+		return nil
+	}
+	for {
+		tv, err := src.call("CLUSTER", "GETKEYSINSLOT", strconv.Itoa(slot), strconv.Itoa(clusterOpts.Pipeline))
+		if err != nil {
+			return fmt.Errorf("Calling CLUSTER GETKEYSINSLOT on %s: %w", src.addr, err)
+		}
+		keys := listOf(tv)
+		if len(keys) == 0 {
+			return nil
+		}
+		argv := []string{"MIGRATE", dst.conn.host, strconv.Itoa(dst.conn.port), "", "0", strconv.Itoa(clusterOpts.Timeout)}
+		if clusterOpts.Replace {
+			argv = append(argv, "REPLACE")
+		}
+		if cv.pass != "" {
+			if cv.args.User != "" {
+				argv = append(argv, "AUTH2", cv.args.User, cv.pass)
+			} else {
+				argv = append(argv, "AUTH", cv.pass)
+			}
+		}
+		argv = append(argv, "KEYS")
+		for _, key := range keys {
+			argv = append(argv, key.String())
+		}
+		if _, err := src.call(argv...); err != nil {
+			if strings.HasPrefix(err.Error(), "BUSYKEY") {
+				return fmt.Errorf("Calling MIGRATE: %w, use --cluster-replace to overwrite keys in target node", err)
+			}
+			return fmt.Errorf("Calling MIGRATE: %w", err)
+		}
+		fmt.Print(".")
+	}
+}
+
+// move slot with its keys from src to dst: mark it importing and migrating,
+// migrate the keys, then assign it to dst on every master
+func (cv *clusterView) moveSlot(src, dst *clusterNode, slot int) error {
+	s := strconv.Itoa(slot)
+	if !clusterOpts.DryRun {
+		fmt.Printf("Moving slot %d from %s to %s: ", slot, src.addr, dst.addr)
+	}
+	if err := cv.apply(dst, "CLUSTER", "SETSLOT", s, "IMPORTING", src.id); err != nil {
+		return err
+	}
+	if err := cv.apply(src, "CLUSTER", "SETSLOT", s, "MIGRATING", dst.id); err != nil {
+		return err
+	}
+	if err := cv.migrateKeys(src, dst, slot); err != nil {
+		return err
+	}
+	// target and source first, so the slot is served even if other masters fail
+	notified := map[*clusterNode]bool{}
+	for _, node := range append([]*clusterNode{dst, src}, cv.masters()...) {
+		if notified[node] || node.conn == nil {
+			continue
+		}
+		notified[node] = true
+		if err := cv.apply(node, "CLUSTER", "SETSLOT", s, "NODE", dst.id); err != nil {
+			return err
+		}
+	}
+	if !clusterOpts.DryRun {
+		fmt.Println()
+	}
+	src.slots = removeSlot(src.slots, slot)
+	dst.slots = append(dst.slots, slot)
+	return nil
+}
+
+func removeSlot(slots []int, slot int) []int {
+	var res []int
+	for _, s := range slots {
+		if s != slot {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// take count slots from sources in proportion to the slots they have, bigger nodes first
+func reshardPlan(sources []*clusterNode, count int) []slotMove {
+	total := 0
+	for _, node := range sources {
+		total += len(node.slots)
+	}
+	sorted := append([]*clusterNode(nil), sources...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].slots) > len(sorted[j].slots)
+	})
+	var plan []slotMove
+	for i, node := range sorted {
+		n := float64(count) / float64(total) * float64(len(node.slots))
+		if i == 0 {
+			n = math.Ceil(n)
+		} else {
+			n = math.Floor(n)
+		}
+		slots := append([]int(nil), node.slots...)
+		sort.Ints(slots)
+		for j := 0; j < int(n) && j < len(slots) && len(plan) < count; j++ {
+			plan = append(plan, slotMove{source: node, slot: slots[j]})
+		}
+	}
+	return plan
+}
+
+// --cluster create host1:port1 ... hostN:portN [--cluster-replicas N]
+func clusterManagerCreate(argv []string) int {
+	if len(argv) == 0 {
+		fmt.Println("[ERR] Wrong number of arguments for specified --cluster sub command")
+		return 1
+	}
+	cv := &clusterView{args: args}
+	defer cv.close()
+	for _, addr := range argv {
+		host, port, err := parseHostPort(addr, 6379)
+		if err != nil {
+			fmt.Printf("[ERR] Invalid address %s\n", addr)
+			return 1
+		}
+		node, err := cv.connectNode(host, port)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		cv.nodes = append(cv.nodes, node)
+		if err := node.checkEmpty(); err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+	}
+	replicas := clusterOpts.Replicas
+	masters := len(cv.nodes) / (replicas + 1)
+	if masters < 3 {
+		fmt.Printf("*** ERROR: Invalid configuration for cluster creation.\n"+
+			"*** Redis Cluster requires at least 3 master nodes.\n"+
+			"*** This is not possible with %d nodes and %d replicas per node.\n"+
+			"*** At least %d nodes are required.\n", len(cv.nodes), replicas, 3*(replicas+1))
+		return 1
+	}
+
+	fmt.Printf(">>> Performing hash slots allocation on %d nodes...\n", len(cv.nodes))
+	ordered := interleaveByHost(cv.nodes)
+	masterNodes, replicaNodes := ordered[:masters], ordered[masters:]
+	assignSlots(masterNodes)
+	assignReplicas(masterNodes, replicaNodes)
+	cv.showNodes()
+	if !clusterOpts.DryRun && !confirm("Can I set the above configuration? (type 'yes' to accept): ") {
+		return 1
+	}
+
+	fmt.Println(">>> Nodes configuration updated")
+	fmt.Println(">>> Assign a different config epoch to each node")
+	for i, node := range cv.nodes {
+		// fails if node already has an epoch, which is fine
+		_ = cv.apply(node, "CLUSTER", "SET-CONFIG-EPOCH", strconv.Itoa(i+1))
+	}
+	for _, node := range masterNodes {
+		if err := cv.addSlots(node, node.slots); err != nil {
+			fmt.Printf("[ERR] %s\n", err.Error())
+			return 1
+		}
+	}
+	fmt.Println(">>> Sending CLUSTER MEET messages to join the cluster")
+	first := cv.nodes[0]
+	for _, node := range cv.nodes[1:] {
+		if err := cv.apply(node, "CLUSTER", "MEET", first.conn.host, strconv.Itoa(first.conn.port)); err != nil {
+			fmt.Printf("[ERR] %s\n", err.Error())
+			return 1
+		}
+	}
+	if err := cv.waitJoin(); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	// replicas can only be configured after they learn about their master
+	for _, node := range replicaNodes {
+		if err := cv.apply(node, "CLUSTER", "REPLICATE", node.masterId); err != nil {
+			fmt.Printf("[ERR] %s\n", err.Error())
+			return 1
+		}
+	}
+	if clusterOpts.DryRun {
+		return 0
+	}
+	return recheck(first)
+}
+
+// load and check the cluster again after it's changed
+func recheck(seed *clusterNode) int {
+	cv, err := loadCluster(args, seed.conn.host, seed.conn.port)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer cv.close()
+	if !cv.check() {
+		return 1
+	}
+	return 0
+}
+
+// order nodes so that consecutive nodes are on different hosts when possible,
+// so masters are spread over hosts
+func interleaveByHost(nodes []*clusterNode) []*clusterNode {
+	var hosts []string
+	byHost := map[string][]*clusterNode{}
+	for _, node := range nodes {
+		host := node.conn.host
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], node)
+	}
+	var res []*clusterNode
+	for len(res) < len(nodes) {
+		for _, host := range hosts {
+			if len(byHost[host]) > 0 {
+				res = append(res, byHost[host][0])
+				byHost[host] = byHost[host][1:]
+			}
+		}
+	}
+	return res
+}
+
+// split slots evenly over masters
+func assignSlots(masters []*clusterNode) {
+	perNode := float64(clusterSlots) / float64(len(masters))
+	first, cursor := 0, 0.0
+	for i, node := range masters {
+		last := int(math.Round(cursor + perNode - 1))
+		if last > clusterSlots-1 || i == len(masters)-1 {
+			last = clusterSlots - 1
+		}
+		if last < first {
+			last = first
+		}
+		fmt.Printf("Master[%d] -> Slots %d - %d\n", i, first, last)
+		node.slots = slotRange(first, last)
+		node.flags = []string{"master"}
+		first = last + 1
+		cursor += perNode
+	}
+}
+
+// assign replicas to masters in turn, avoiding the host of the master when possible
+func assignReplicas(masters, replicas []*clusterNode) {
+	pending := append([]*clusterNode(nil), replicas...)
+	for len(pending) > 0 {
+		for _, master := range masters {
+			if len(pending) == 0 {
+				break
+			}
+			pick := 0
+			for i, node := range pending {
+				if node.conn.host != master.conn.host {
+					pick = i
+					break
+				}
+			}
+			replica := pending[pick]
+			pending = append(pending[:pick], pending[pick+1:]...)
+			replica.flags = []string{"slave"}
+			replica.masterId = master.id
+			master.replicas = append(master.replicas, replica)
+			fmt.Printf("Adding replica %s to %s\n", replica.addr, master.addr)
+		}
+	}
+}
+
+// --cluster add-node new_host:new_port existing_host:existing_port [--cluster-slave [--cluster-master-id id]]
+func clusterManagerAddNode(argv []string) int {
+	if len(argv) != 2 {
+		fmt.Println("[ERR] Wrong number of arguments for specified --cluster sub command")
+		return 1
+	}
+	host, port, err := parseHostPort(argv[0], 6379)
+	if err != nil {
+		fmt.Printf("[ERR] Invalid address %s\n", argv[0])
+		return 1
+	}
+	fmt.Printf(">>> Adding node %s to cluster %s\n", argv[0], argv[1])
+	cv, err := loadClusterFromArgs(argv[1:])
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer cv.close()
+	if err := cv.requireReachable(); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	cv.check()
+
+	var master *clusterNode
+	if clusterOpts.Slave || clusterOpts.Replica {
+		if clusterOpts.MasterId != "" {
+			master = cv.findNode(clusterOpts.MasterId)
+			if master == nil || !master.isMaster() {
+				fmt.Printf("[ERR] No such master ID %s\n", clusterOpts.MasterId)
+				return 1
+			}
+		} else {
+			master = cv.leastReplicatedMaster(nil)
+			if master == nil {
+				fmt.Println("[ERR] No master to replicate")
+				return 1
+			}
+			fmt.Printf("Automatically selected master %s\n", master.addr)
+		}
+	}
+
+	node, err := cv.connectNode(host, port)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	cv.nodes = append(cv.nodes, node)
+	if err := node.checkEmpty(); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	fmt.Printf(">>> Send CLUSTER MEET to node %s to make it join the cluster.\n", node.addr)
+	seed := cv.seed.conn
+	if err := cv.apply(node, "CLUSTER", "MEET", seed.host, strconv.Itoa(seed.port)); err != nil {
+		fmt.Printf("[ERR] %s\n", err.Error())
+		return 1
+	}
+	if master != nil {
+		if err := cv.waitJoin(); err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		fmt.Printf(">>> Configure node as replica of %s.\n", master.addr)
+		if err := cv.apply(node, "CLUSTER", "REPLICATE", master.id); err != nil {
+			fmt.Printf("[ERR] %s\n", err.Error())
+			return 1
+		}
+	}
+	fmt.Println("[OK] New node added correctly.")
+	return 0
+}
+
+// --cluster del-node host:port node_id
+func clusterManagerDelNode(argv []string) int {
+	if len(argv) != 2 {
+		fmt.Println("[ERR] Wrong number of arguments for specified --cluster sub command")
+		return 1
+	}
+	id := argv[1]
+	fmt.Printf(">>> Removing node %s from cluster %s\n", id, argv[0])
+	cv, err := loadClusterFromArgs(argv[:1])
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer cv.close()
+	if err := cv.requireReachable(); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	node := cv.findNode(id)
+	if node == nil {
+		fmt.Printf("[ERR] No such node ID %s\n", id)
+		return 1
+	}
+	if len(node.slots) > 0 {
+		fmt.Printf("[ERR] Node %s is not empty! Reshard data away and try again.\n", node.addr)
+		return 1
+	}
+
+	fmt.Println(">>> Sending CLUSTER FORGET messages to the cluster...")
+	for _, other := range cv.nodes {
+		if other == node || other.conn == nil {
+			continue
+		}
+		if other.masterId == node.id {
+			// replicas of the removed node need a new master
+			if master := cv.leastReplicatedMaster(node); master != nil {
+				fmt.Printf(">>> %s as replica of %s\n", other.addr, master.addr)
+				if err := cv.apply(other, "CLUSTER", "REPLICATE", master.id); err != nil {
+					fmt.Printf("[ERR] %s\n", err.Error())
+					return 1
+				}
+				master.replicas = append(master.replicas, other)
+			}
+		}
+		if err := cv.apply(other, "CLUSTER", "FORGET", node.id); err != nil {
+			fmt.Printf("[ERR] %s\n", err.Error())
+			return 1
+		}
+	}
+	if node.conn != nil {
+		fmt.Println(">>> Sending CLUSTER RESET SOFT to the deleted node.")
+		if err := cv.apply(node, "CLUSTER", "RESET", "SOFT"); err != nil {
+			fmt.Printf("[ERR] %s\n", err.Error())
+			return 1
+		}
+	}
+	return 0
+}
+
+// --cluster reshard host:port --cluster-from ids --cluster-to id --cluster-slots N
+// missing options are asked interactively
+func clusterManagerReshard(argv []string) int {
+	cv, err := loadClusterFromArgs(argv)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer cv.close()
+	if !cv.check() {
+		fmt.Println("*** Please fix your cluster problems before resharding")
+		return 1
+	}
+
+	count := clusterOpts.Slots
+	for count <= 0 || count > clusterSlots {
+		answer := ask(fmt.Sprintf("How many slots do you want to move (from 1 to %d)? ", clusterSlots))
+		if answer == "" {
+			return 1
+		}
+		count, _ = strconv.Atoi(answer)
+	}
+	to := clusterOpts.To
+	if to == "" {
+		to = ask("What is the receiving node ID? ")
+	}
+	target := cv.findNode(to)
+	if target == nil || !target.isMaster() {
+		fmt.Printf("*** The specified node (%s) is not known or not a master, please retry.\n", to)
+		return 1
+	}
+	sources, err := cv.reshardSources(target)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+
+	fmt.Printf("\nReady to move %d slots.\n", count)
+	fmt.Println("  Source nodes:")
+	for _, node := range sources {
+		fmt.Printf("    M: %s %s\n       slots:[%s] (%d slots) master\n", node.id, node.addr, formatSlotRanges(node.slots), len(node.slots))
+	}
+	fmt.Println("  Destination node:")
+	fmt.Printf("    M: %s %s\n       slots:[%s] (%d slots) master\n", target.id, target.addr, formatSlotRanges(target.slots), len(target.slots))
+	plan := reshardPlan(sources, count)
+	fmt.Println("  Resharding plan:")
+	for _, move := range plan {
+		fmt.Printf("    Moving slot %d from %s\n", move.slot, move.source.id)
+	}
+	if !clusterOpts.DryRun && !confirm("Do you want to proceed with the proposed reshard plan (yes/no)? ") {
+		return 1
+	}
+	for _, move := range plan {
+		if err := cv.moveSlot(move.source, target, move.slot); err != nil {
+			fmt.Printf("\n[ERR] %s\n", err.Error())
+			return 1
+		}
+	}
+	return 0
+}
+
+// source nodes from --cluster-from, or asked one by one
+func (cv *clusterView) reshardSources(target *clusterNode) ([]*clusterNode, error) {
+	var ids []string
+	if clusterOpts.From != "" {
+		ids = strings.Split(clusterOpts.From, ",")
+	} else {
+		fmt.Println("Please enter all the source node IDs.")
+		fmt.Println("  Type 'all' to use all the nodes as source nodes for the hash slots.")
+		fmt.Println("  Type 'done' once you entered all the source nodes IDs.")
+		for {
+			id := ask(fmt.Sprintf("Source node #%d: ", len(ids)+1))
+			if id == "" || id == "done" {
+				break
+			}
+			ids = append(ids, id)
+			if id == "all" {
+				break
+			}
+		}
+	}
+	var sources []*clusterNode
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "all" {
+			sources = nil
+			for _, node := range cv.masters() {
+				if node != target && node.conn != nil && len(node.slots) > 0 {
+					sources = append(sources, node)
+				}
+			}
+			break
+		}
+		node := cv.findNode(id)
+		if node == nil || !node.isMaster() {
+			return nil, fmt.Errorf("*** The specified node (%s) is not known or is not a master, please retry.", id)
+		}
+		if node == target {
+			return nil, errors.New("*** It is not possible to use the target node as source node.")
+		}
+		sources = append(sources, node)
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("*** No source nodes given, operation aborted.")
+	}
+	return sources, nil
+}
+
+// master with its weight and how many slots it has over (positive) or under (negative) its share
+type balanceNode struct {
+	node    *clusterNode
+	weight  float64
+	balance int
+}
+
+// --cluster rebalance host:port [--cluster-weight id=w ...] [--cluster-use-empty-masters] [--cluster-threshold pct]
+func clusterManagerRebalance(argv []string) int {
+	cv, err := loadClusterFromArgs(argv)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer cv.close()
+	if !cv.check() {
+		fmt.Println("*** Please fix your cluster problems before rebalancing")
+		return 1
+	}
+	weights, err := cv.parseWeights(clusterOpts.Weight)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+
+	var nodes []*balanceNode
+	totalWeight := 0.0
+	for _, node := range cv.masters() {
+		if node.conn == nil {
+			continue
+		}
+		weight, set := weights[node]
+		if !set {
+			weight = 1
+		}
+		// empty masters only take slots if asked, or given a weight
+		if len(node.slots) == 0 && !set && !clusterOpts.UseEmptyMasters {
+			continue
+		}
+		nodes = append(nodes, &balanceNode{node: node, weight: weight})
+		totalWeight += weight
+	}
+	if totalWeight == 0 {
+		fmt.Println("*** No master with weight to rebalance to")
+		return 1
+	}
+
+	if !computeBalance(nodes, totalWeight, clusterOpts.Threshold) {
+		fmt.Printf("*** No rebalancing needed! All nodes are within the %.2f%% threshold.\n", clusterOpts.Threshold)
+		return 0
+	}
+	fmt.Printf(">>> Rebalancing across %d nodes. Total weight = %.2f\n", len(nodes), totalWeight)
+	for _, move := range rebalanceMoves(nodes) {
+		fmt.Printf("Moving %d slots from %s to %s\n", move.count, move.from.addr, move.to.addr)
+		for _, slot := range reshardPlan([]*clusterNode{move.from}, move.count) {
+			if err := cv.moveSlot(slot.source, move.to, slot.slot); err != nil {
+				fmt.Printf("\n[ERR] %s\n", err.Error())
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// set balance of nodes by their share of slots by weight, returns false if no node is off
+// its share by more than threshold percent, so there's nothing to rebalance
+func computeBalance(nodes []*balanceNode, totalWeight, threshold float64) bool {
+	thresholdReached := false
+	totalBalance := 0
+	for _, bn := range nodes {
+		expected := int(float64(clusterSlots) / totalWeight * bn.weight)
+		bn.balance = len(bn.node.slots) - expected
+		totalBalance += bn.balance
+		if len(bn.node.slots) == 0 {
+			thresholdReached = thresholdReached || expected > 0
+		} else if math.Abs(100-100*float64(expected)/float64(len(bn.node.slots))) > threshold {
+			thresholdReached = true
+		}
+	}
+	if !thresholdReached {
+		return false
+	}
+	// expected slots are rounded down, nodes not over their share take the rest
+	for totalBalance > 0 {
+		changed := false
+		for _, bn := range nodes {
+			if bn.balance <= 0 && totalBalance > 0 {
+				bn.balance--
+				totalBalance--
+				changed = true
+			}
+		}
+		if !changed {
+			nodes[0].balance--
+			totalBalance--
+		}
+	}
+	return true
+}
+
+// slots moved from a node over its share to one under it
+type rebalanceMove struct {
+	from, to *clusterNode
+	count    int
+}
+
+// pair nodes most under their share with nodes most over it, until all are balanced,
+// balance of nodes is consumed
+func rebalanceMoves(nodes []*balanceNode) []rebalanceMove {
+	sorted := append([]*balanceNode(nil), nodes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].balance < sorted[j].balance
+	})
+	var moves []rebalanceMove
+	dst, src := 0, len(sorted)-1
+	for dst < src {
+		to, from := sorted[dst], sorted[src]
+		n := min(-to.balance, from.balance)
+		if n <= 0 {
+			break
+		}
+		moves = append(moves, rebalanceMove{from: from.node, to: to.node, count: n})
+		to.balance += n
+		from.balance -= n
+		if to.balance == 0 {
+			dst++
+		}
+		if from.balance == 0 {
+			src--
+		}
+	}
+	return moves
+}
+
+// "id1=w1 id2=w2", ids may be prefixes
+func (cv *clusterView) parseWeights(spec string) (map[*clusterNode]float64, error) {
+	weights := map[*clusterNode]float64{}
+	for _, item := range strings.Fields(spec) {
+		id, w, ok := strings.Cut(item, "=")
+		weight, err := strconv.ParseFloat(w, 64)
+		if !ok || err != nil || weight < 0 {
+			return nil, fmt.Errorf("*** Invalid weight '%s', use <node id>=<weight>", item)
+		}
+		node := cv.findNode(id)
+		if node == nil || !node.isMaster() {
+			return nil, fmt.Errorf("*** No such master node %s", id)
+		}
+		weights[node] = weight
+	}
+	return weights, nil
+}
+
+// --cluster fix host:port [--cluster-search-multiple-owners]
+func clusterManagerFix(argv []string) int {
+	cv, err := loadClusterFromArgs(argv)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer cv.close()
+	cv.showInfo()
+	healthy := cv.check()
+	if err := cv.requireReachable(); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+
+	ok := true
+	if open := cv.openSlots(); len(open) > 0 {
+		fmt.Println(">>> Fixing open slots...")
+		for _, slot := range open {
+			if err := cv.fixOpenSlot(slot); err != nil {
+				fmt.Printf("[ERR] %s\n", err.Error())
+				ok = false
+			}
+		}
+	}
+	if uncovered := cv.uncoveredSlots(); len(uncovered) > 0 {
+		fmt.Println(">>> Fixing slots coverage...")
+		if err := cv.fixSlotsCoverage(uncovered); err != nil {
+			fmt.Printf("[ERR] %s\n", err.Error())
+			ok = false
+		}
+	}
+	if clusterOpts.SearchMultipleOwner {
+		fmt.Println(">>> Check for multiple slot owners...")
+		if err := cv.fixMultipleOwners(); err != nil {
+			fmt.Printf("[ERR] %s\n", err.Error())
+			ok = false
+		}
+	}
+	if !ok {
+		return 1
+	}
+	if clusterOpts.DryRun || (healthy && !clusterOpts.SearchMultipleOwner) {
+		return 0
+	}
+	return recheck(cv.seed)
+}
+
+// close open slot: find or pick its owner, then finish the interrupted migration,
+// or move keys back to the owner, and mark the slot stable
+func (cv *clusterView) fixOpenSlot(slot int) error {
+	s := strconv.Itoa(slot)
+	fmt.Printf(">>> Fixing open slot %d\n", slot)
+	var owners, migrating, importing []*clusterNode
+	keys := map[*clusterNode]int{}
+	for _, node := range cv.masters() {
+		if node.conn == nil {
+			continue
+		}
+		count, err := cv.keysInSlot(node, slot)
+		if err != nil {
+			return err
+		}
+		keys[node] = count
+		if hasSlot(node, slot) {
+			owners = append(owners, node)
+		}
+		if _, ok := node.migrating[slot]; ok {
+			migrating = append(migrating, node)
+		} else if _, ok := node.importing[slot]; ok {
+			importing = append(importing, node)
+		} else if count > 0 && !hasSlot(node, slot) {
+			fmt.Printf("*** Found keys about slot %d in non-owner node %s!\n", slot, node.addr)
+			importing = append(importing, node)
+		}
+	}
+	if len(migrating) > 0 {
+		fmt.Printf("Set as migrating in: %s\n", nodeAddrs(migrating))
+	}
+	if len(importing) > 0 {
+		fmt.Printf("Set as importing in: %s\n", nodeAddrs(importing))
+	}
+
+	var owner *clusterNode
+	switch len(owners) {
+	case 0:
+		// nobody owns the slot, the node with most keys takes it
+		owner = mostKeys(append(append([]*clusterNode(nil), migrating...), importing...), keys)
+		if owner == nil {
+			return fmt.Errorf("Slot %d has no owner and no node to assign it to", slot)
+		}
+		fmt.Printf("*** Configuring %s as the slot owner\n", owner.addr)
+		if err := cv.apply(owner, "CLUSTER", "SETSLOT", s, "STABLE"); err != nil {
+			return err
+		}
+		if err := cv.addSlots(owner, []int{slot}); err != nil {
+			return err
+		}
+		_ = cv.apply(owner, "CLUSTER", "BUMPEPOCH")
+		migrating, importing = withoutNode(migrating, owner), withoutNode(importing, owner)
+	case 1:
+		owner = owners[0]
+	default:
+		// more owners, the one with most keys keeps the slot, others give their keys to it
+		owner = mostKeys(owners, keys)
+		fmt.Printf("*** Configuring %s as the slot owner\n", owner.addr)
+		for _, node := range owners {
+			if node == owner {
+				continue
+			}
+			if err := cv.apply(node, "CLUSTER", "DELSLOTS", s); err != nil {
+				return err
+			}
+			if err := cv.apply(node, "CLUSTER", "SETSLOT", s, "IMPORTING", owner.id); err != nil {
+				return err
+			}
+			migrating = withoutNode(migrating, node)
+			importing = append(withoutNode(importing, node), node)
+		}
+		_ = cv.apply(owner, "CLUSTER", "BUMPEPOCH")
+	}
+
+	switch {
+	case len(migrating) == 1 && len(importing) == 1:
+		// migration was interrupted, finish it
+		return cv.moveSlot(migrating[0], importing[0], slot)
+	case len(migrating) == 0 && len(importing) > 0:
+		// keys are left in importing nodes, move them back to the owner
+		fmt.Printf("Moving all the %d slot keys to its owner %s\n", slot, owner.addr)
+		for _, node := range withoutNode(importing, owner) {
+			if err := cv.migrateKeys(node, owner, slot); err != nil {
+				return err
+			}
+			if !clusterOpts.DryRun {
+				fmt.Println()
+			}
+			if err := cv.apply(node, "CLUSTER", "SETSLOT", s, "STABLE"); err != nil {
+				return err
+			}
+		}
+		return cv.apply(owner, "CLUSTER", "SETSLOT", s, "STABLE")
+	case len(migrating) == 1 && len(importing) == 0 && migrating[0] == owner:
+		// target of the migration is gone, keys are still in the owner
+		return cv.apply(owner, "CLUSTER", "SETSLOT", s, "STABLE")
+	case len(migrating) == 0 && len(importing) == 0:
+		return cv.apply(owner, "CLUSTER", "SETSLOT", s, "STABLE")
+	}
+	return fmt.Errorf("Sorry, can't fix this slot yet (work in progress). "+
+		"Slot is set as migrating in %s, as importing in %s, owner is %s",
+		nodeAddrs(migrating), nodeAddrs(importing), owner.addr)
+}
+
+// assign uncovered slots, to the node holding their keys if any
+func (cv *clusterView) fixSlotsCoverage(uncovered []int) error {
+	var none, single, multi []int
+	holders := map[int][]*clusterNode{}
+	keys := map[int]map[*clusterNode]int{}
+	for _, slot := range uncovered {
+		keys[slot] = map[*clusterNode]int{}
+		for _, node := range cv.masters() {
+			if node.conn == nil {
+				continue
+			}
+			count, err := cv.keysInSlot(node, slot)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				holders[slot] = append(holders[slot], node)
+				keys[slot][node] = count
+			}
+		}
+		switch len(holders[slot]) {
+		case 0:
+			none = append(none, slot)
+		case 1:
+			single = append(single, slot)
+		default:
+			multi = append(multi, slot)
+		}
+	}
+
+	if len(none) > 0 {
+		fmt.Printf("The following uncovered slots have no keys across the cluster:\n%s\n", formatSlotRanges(none))
+		if clusterOpts.DryRun || confirm("Fix these slots by covering with a random node? (type 'yes' to accept): ") {
+			// spread slots over masters in turn
+			var masters []*clusterNode
+			for _, node := range cv.masters() {
+				if node.conn != nil {
+					masters = append(masters, node)
+				}
+			}
+			if len(masters) == 0 {
+				return errors.New("No master to cover slots with")
+			}
+			assigned := map[*clusterNode][]int{}
+			for i, slot := range none {
+				node := masters[i%len(masters)]
+				assigned[node] = append(assigned[node], slot)
+			}
+			for _, node := range masters {
+				if len(assigned[node]) == 0 {
+					continue
+				}
+				fmt.Printf(">>> Covering slots %s with %s\n", formatSlotRanges(assigned[node]), node.addr)
+				if err := cv.addSlots(node, assigned[node]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if len(single) > 0 {
+		fmt.Printf("The following uncovered slots have keys in just one node:\n%s\n", formatSlotRanges(single))
+		if clusterOpts.DryRun || confirm("Fix these slots by covering with those nodes? (type 'yes' to accept): ") {
+			for _, slot := range single {
+				node := holders[slot][0]
+				fmt.Printf(">>> Covering slot %d with %s\n", slot, node.addr)
+				if err := cv.addSlots(node, []int{slot}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if len(multi) > 0 {
+		fmt.Printf("The following uncovered slots have keys in multiple nodes:\n%s\n", formatSlotRanges(multi))
+		if clusterOpts.DryRun || confirm("Fix these slots by moving keys into a single node? (type 'yes' to accept): ") {
+			for _, slot := range multi {
+				owner := mostKeys(holders[slot], keys[slot])
+				fmt.Printf(">>> Covering slot %d moving keys to %s\n", slot, owner.addr)
+				if err := cv.addSlots(owner, []int{slot}); err != nil {
+					return err
+				}
+				_ = cv.apply(owner, "CLUSTER", "BUMPEPOCH")
+				for _, node := range withoutNode(holders[slot], owner) {
+					if err := cv.moveKeysToOwner(node, owner, slot); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// find slots claimed by more than one master in their own CLUSTER NODES view, the one having most keys
+// keeps the slot, and keys of the others are moved to it
+func (cv *clusterView) fixMultipleOwners() error {
+	claims := map[int][]*clusterNode{}
+	for _, node := range cv.masters() {
+		if node.conn == nil {
+			continue
+		}
+		for _, slot := range node.slots {
+			claims[slot] = append(claims[slot], node)
+		}
+	}
+	var slots []int
+	for slot, nodes := range claims {
+		if len(nodes) > 1 {
+			slots = append(slots, slot)
+		}
+	}
+	sort.Ints(slots)
+	for _, slot := range slots {
+		var owner *clusterNode
+		most := -1
+		for _, node := range claims[slot] {
+			count, err := cv.keysInSlot(node, slot)
+			if err != nil {
+				return err
+			}
+			if count > most {
+				owner, most = node, count
+			}
+		}
+		var others []*clusterNode
+		for _, node := range claims[slot] {
+			if node != owner {
+				others = append(others, node)
+			}
+		}
+		fmt.Printf("[WARNING] Slot %d has %d owners: %s, %s\n", slot, len(others)+1, owner.addr, nodeAddrs(others))
+		for _, node := range others {
+			if err := cv.moveKeysToOwner(node, owner, slot); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// move keys of slot from a node which shouldn't have them to the owner,
+// MIGRATE works with keys of a slot not served by the node while the slot is open
+func (cv *clusterView) moveKeysToOwner(node, owner *clusterNode, slot int) error {
+	s := strconv.Itoa(slot)
+	if hasSlot(node, slot) {
+		if err := cv.apply(node, "CLUSTER", "DELSLOTS", s); err != nil {
+			return err
+		}
+	}
+	if err := cv.apply(node, "CLUSTER", "SETSLOT", s, "IMPORTING", owner.id); err != nil {
+		return err
+	}
+	if err := cv.migrateKeys(node, owner, slot); err != nil {
+		return err
+	}
+	if !clusterOpts.DryRun {
+		fmt.Println()
+	}
+	return cv.apply(node, "CLUSTER", "SETSLOT", s, "STABLE")
+}
+
+func mostKeys(nodes []*clusterNode, keys map[*clusterNode]int) *clusterNode {
+	var res *clusterNode
+	for _, node := range nodes {
+		if res == nil || keys[node] > keys[res] {
+			res = node
+		}
+	}
+	return res
+}
+
+func withoutNode(nodes []*clusterNode, node *clusterNode) []*clusterNode {
+	var res []*clusterNode
+	for _, n := range nodes {
+		if n != node {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+func nodeAddrs(nodes []*clusterNode) string {
+	var addrs []string
+	for _, node := range nodes {
+		addrs = append(addrs, node.addr)
+	}
+	return strings.Join(addrs, ",")
+}
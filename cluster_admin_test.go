@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReshardPlan(t *testing.T) {
+	a := &clusterNode{addr: "a:1", slots: slotRange(0, 99)}
+	b := &clusterNode{addr: "b:2", slots: slotRange(100, 149)}
+	c := &clusterNode{addr: "c:3", slots: slotRange(200, 299)}
+	tests := []struct {
+		name    string
+		sources []*clusterNode
+		count   int
+		want    map[string][]int
+	}{
+		{
+			name:    "in proportion to slots",
+			sources: []*clusterNode{b, a},
+			count:   30,
+			want:    map[string][]int{"a:1": slotRange(0, 19), "b:2": slotRange(100, 109)},
+		},
+		{
+			// the biggest node rounds up, the others round down
+			name:    "rounding",
+			sources: []*clusterNode{a, c, b},
+			count:   10,
+			want:    map[string][]int{"a:1": slotRange(0, 3), "c:3": slotRange(200, 203), "b:2": slotRange(100, 101)},
+		},
+		{
+			name:    "all slots",
+			sources: []*clusterNode{b},
+			count:   50,
+			want:    map[string][]int{"b:2": slotRange(100, 149)},
+		},
+		{
+			name:    "single source",
+			sources: []*clusterNode{a},
+			count:   1,
+			want:    map[string][]int{"a:1": {0}},
+		},
+	}
+	for _, tt := range tests {
+		plan := reshardPlan(tt.sources, tt.count)
+		got := map[string][]int{}
+		for _, move := range plan {
+			got[move.source.addr] = append(got[move.source.addr], move.slot)
+		}
+		if len(plan) > tt.count || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("reshardPlan(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAssignSlots(t *testing.T) {
+	tests := []struct {
+		masters int
+		want    [][2]int
+	}{
+		{masters: 1, want: [][2]int{{0, 16383}}},
+		{masters: 3, want: [][2]int{{0, 5460}, {5461, 10922}, {10923, 16383}}},
+		{masters: 4, want: [][2]int{{0, 4095}, {4096, 8191}, {8192, 12287}, {12288, 16383}}},
+		{masters: 5, want: [][2]int{{0, 3276}, {3277, 6553}, {6554, 9829}, {9830, 13106}, {13107, 16383}}},
+	}
+	for _, tt := range tests {
+		masters := make([]*clusterNode, tt.masters)
+		for i := range masters {
+			masters[i] = &clusterNode{}
+		}
+		assignSlots(masters)
+		var got [][2]int
+		for _, node := range masters {
+			if !node.isMaster() {
+				t.Errorf("assignSlots(%d) node flags = %v, want master", tt.masters, node.flags)
+			}
+			got = append(got, slotRanges(node.slots)...)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("assignSlots(%d) = %v, want %v", tt.masters, got, tt.want)
+		}
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	type master struct {
+		slots  int
+		weight float64
+	}
+	type move struct {
+		from, to string
+		count    int
+	}
+	tests := []struct {
+		name      string
+		masters   []master
+		threshold float64
+		needed    bool
+		want      []move
+	}{
+		{
+			name:      "balanced",
+			masters:   []master{{5461, 1}, {5462, 1}, {5461, 1}},
+			threshold: 2,
+		},
+		{
+			// off by one slot, which is under 2% but over 0%
+			name:      "zero threshold",
+			masters:   []master{{5461, 1}, {5462, 1}, {5461, 1}},
+			threshold: 0,
+			needed:    true,
+			want:      []move{{from: "m1", to: "m0", count: 1}},
+		},
+		{
+			name:      "weight",
+			masters:   []master{{5461, 2}, {5462, 1}, {5461, 1}},
+			threshold: 2,
+			needed:    true,
+			want:      []move{{from: "m1", to: "m0", count: 1366}, {from: "m2", to: "m0", count: 1365}},
+		},
+		{
+			name:      "empty master",
+			masters:   []master{{8192, 1}, {8192, 1}, {0, 1}},
+			threshold: 2,
+			needed:    true,
+			want:      []move{{from: "m1", to: "m2", count: 2731}, {from: "m0", to: "m2", count: 2731}},
+		},
+		{
+			name:      "weight 0 drains node",
+			masters:   []master{{8192, 1}, {8192, 0}},
+			threshold: 2,
+			needed:    true,
+			want:      []move{{from: "m1", to: "m0", count: 8192}},
+		},
+	}
+	for _, tt := range tests {
+		var nodes []*balanceNode
+		totalWeight := 0.0
+		first := 0
+		for i, m := range tt.masters {
+			node := &clusterNode{addr: "m" + string(rune('0'+i)), slots: slotRange(first, first+m.slots-1)}
+			first += m.slots
+			nodes = append(nodes, &balanceNode{node: node, weight: m.weight})
+			totalWeight += m.weight
+		}
+		if needed := computeBalance(nodes, totalWeight, tt.threshold); needed != tt.needed {
+			t.Errorf("computeBalance(%s) = %v, want %v", tt.name, needed, tt.needed)
+			continue
+		}
+		if !tt.needed {
+			continue
+		}
+		total := 0
+		for _, bn := range nodes {
+			total += bn.balance
+		}
+		if total != 0 {
+			t.Errorf("computeBalance(%s) balances sum to %d, want 0", tt.name, total)
+		}
+		var got []move
+		for _, m := range rebalanceMoves(nodes) {
+			got = append(got, move{from: m.from.addr, to: m.to.addr, count: m.count})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("rebalanceMoves(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+		for _, bn := range nodes {
+			if bn.balance != 0 {
+				t.Errorf("rebalanceMoves(%s) left %s with balance %d", tt.name, bn.node.addr, bn.balance)
+			}
+		}
+	}
+}
+
+// connection to a fake node replying every command with reply
+func fakeNodeConn(t *testing.T, reply string) *Connection {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	go func() {
+		reader := bufio.NewReader(server)
+		for {
+			if _, err := ReadValue(reader); err != nil {
+				return
+			}
+			if _, err := server.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}()
+	c := NewConnection(&Args{})
+	c.conn, c.bufReader, c.connected = client, bufio.NewReader(client), true
+	return c
+}
+
+func TestWaitJoinTimeout(t *testing.T) {
+	saved := *clusterOpts
+	defer func() { *clusterOpts = saved }()
+	clusterOpts.Timeout, clusterOpts.DryRun = 300, false
+
+	// the new node never learns about the other one
+	line := "1111111111111111111111111111111111111111 127.0.0.1:7000@17000 myself,master - 0 0 1 connected 0-16383\n"
+	reply := "$" + strconv.Itoa(len(line)) + "\r\n" + line + "\r\n"
+	cv := &clusterView{nodes: []*clusterNode{
+		{addr: "127.0.0.1:7000", conn: fakeNodeConn(t, reply)},
+		{addr: "127.0.0.1:7001"},
+	}}
+	start := time.Now()
+	err := cv.waitJoin()
+	if err == nil || !strings.Contains(err.Error(), "--cluster-timeout") {
+		t.Errorf("waitJoin() error = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("waitJoin() took %v, want it bound by --cluster-timeout", elapsed)
+	}
+}
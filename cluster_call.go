@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// node to run a fan-out command on, err is set if it can't be connected
+type callTarget struct {
+	addr string
+	conn *Connection
+	err  error
+}
+
+// reply of one node in fan-out execution
+type nodeReply struct {
+	addr string
+	tv   *TypedVal
+	err  error
+}
+
+// :all [masters|replicas|nodes] [--aggregate] <command> [args...]
+// run command on nodes of the cluster, masters by default
+func (c *Connection) ExecAll(argv []string) error {
+	if c.cluster == nil {
+		return errors.New(":all requires cluster mode (-c)")
+	}
+	which := "masters"
+	if len(argv) > 0 {
+		switch strings.ToLower(argv[0]) {
+		case "masters", "replicas", "nodes":
+			which, argv = strings.ToLower(argv[0]), argv[1:]
+		}
+	}
+	aggregate := len(argv) > 0 && argv[0] == "--aggregate"
+	if aggregate {
+		argv = argv[1:]
+	}
+	if len(argv) == 0 {
+		return errors.New("usage: :all [masters|replicas|nodes] [--aggregate] <command> [args...]")
+	}
+	if aggregate && which != "masters" {
+		// replicas hold the same keys as their masters, so they would be counted twice
+		return errors.New(":all --aggregate runs on masters only")
+	}
+	targets, err := c.cluster.members(which)
+	if err != nil {
+		return err
+	}
+	// Ctrl-C interrupts all nodes still waiting for reply
+	var nodes []*Connection
+	for _, target := range targets {
+		if target.conn != nil {
+			nodes = append(nodes, target.conn)
+		}
+	}
+	c.cluster.fanout.Store(&nodes)
+	defer c.cluster.fanout.Store(nil)
+	return printFanOut(c.writer, argv, fanOut(targets, argv, aggregate), aggregate, c.rawOutput())
+}
+
+// nodes of cluster by role, as the current node sees them
+func (cl *Cluster) members(which string) ([]callTarget, error) {
+	tv, err := cl.current.ExecArgs("CLUSTER", "NODES")
+	if err != nil {
+		return nil, err
+	}
+	if tv.IsError() {
+		return nil, fmt.Errorf("%s", tv.Val)
+	}
+	var targets []callTarget
+	for _, line := range strings.Split(strings.TrimSpace(tv.String()), "\n") {
+		node := parseClusterNodeLine(line)
+		if node == nil || node.hasFlag("fail") || node.hasFlag("noaddr") || node.hasFlag("handshake") {
+			continue
+		}
+		if (which == "masters" && !node.isMaster()) || (which == "replicas" && node.isMaster()) {
+			continue
+		}
+		if node.hasFlag("myself") {
+			_, addr := cl.current.address()
+			targets = append(targets, callTarget{addr: addr, conn: cl.current})
+			continue
+		}
+		conn, err := cl.node(node.addr)
+		targets = append(targets, callTarget{addr: node.addr, conn: conn, err: err})
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].addr < targets[j].addr
+	})
+	return targets, nil
+}
+
+// exec command on every target concurrently, replies are in the order of targets
+func fanOut(targets []callTarget, argv []string, aggregate bool) []nodeReply {
+	replies := make([]nodeReply, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		replies[i].addr = target.addr
+		if target.err != nil {
+			replies[i].err = target.err
+			continue
+		}
+		wg.Add(1)
+		go func(reply *nodeReply, conn *Connection) {
+			defer wg.Done()
+			if aggregate && isCmd(argv, "scan") {
+				reply.tv, reply.err = scanNode(conn, argv)
+			} else {
+				reply.tv, reply.err = conn.ExecArgs(argv...)
+			}
+		}(&replies[i], target.conn)
+	}
+	wg.Wait()
+	return replies
+}
+
+// iterate SCAN until the cursor returns to 0, the cursor given in argv is ignored
+func scanNode(conn *Connection, argv []string) (*TypedVal, error) {
+	scanArgs := []string{"SCAN", "0"}
+	if len(argv) > 2 {
+		scanArgs = append(scanArgs, argv[2:]...)
+	}
+	var keys []*TypedVal
+	for {
+		tv, err := conn.ExecArgs(scanArgs...)
+		if err != nil || tv.IsError() {
+			return tv, err
+		}
+		items := listOf(tv)
+		if len(items) != 2 {
+			return nil, errors.New("unexpected SCAN reply")
+		}
+		keys = append(keys, listOf(items[1])...)
+		scanArgs[1] = items[0].String()
+		if scanArgs[1] == "0" {
+			return &TypedVal{Type: TypeArray, Val: keys}, nil
+		}
+	}
+}
+
+// print replies labeled by node address, or aggregated into one reply,
+// returns error if any node failed
+func printFanOut(w io.Writer, argv []string, replies []nodeReply, aggregate bool, raw *rawFormat) error {
+	_, _ = fmt.Fprintf(w, ">>> Calling %s\n", strings.Join(argv, " "))
+	failed := 0
+	var succeeded []nodeReply
+	for _, reply := range replies {
+		if reply.err != nil || reply.tv.IsError() {
+			failed++
+			printLabeled(w, argv, reply, raw)
+		} else {
+			succeeded = append(succeeded, reply)
+		}
+	}
+	if !aggregate || !printAggregate(w, argv, succeeded, raw) {
+		for _, reply := range succeeded {
+			printLabeled(w, argv, reply, raw)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d nodes failed", failed, len(replies))
+	}
+	return nil
+}
+
+// "127.0.0.1:7000: (integer) 3", replies of more lines start on the next line
+func printLabeled(w io.Writer, argv []string, reply nodeReply, raw *rawFormat) {
+	var sb strings.Builder
+	switch {
+	case reply.err != nil:
+		sb.WriteString(reply.err.Error() + "\n")
+	case isCmd(argv, "info") && !reply.tv.IsError():
+		sb.WriteString(strings.TrimRight(reply.tv.String(), "\r\n") + "\n")
+	default:
+		PrintVal(&sb, reply.tv, raw)
+	}
+	out := sb.String()
+	if strings.Count(out, "\n") > 1 {
+		_, _ = fmt.Fprintf(w, "%s:\n%s", reply.addr, out)
+	} else {
+		_, _ = fmt.Fprintf(w, "%s: %s", reply.addr, out)
+	}
+}
+
+// sum integers, merge SCAN keys into one stream, and min/max of INFO fields,
+// returns false if replies can't be aggregated
+func printAggregate(w io.Writer, argv []string, replies []nodeReply, raw *rawFormat) bool {
+	switch {
+	case isCmd(argv, "scan"):
+		for _, reply := range replies {
+			for _, key := range listOf(reply.tv) {
+				PrintVal(w, key, raw)
+			}
+		}
+		return true
+	case isCmd(argv, "info"):
+		printInfoMinMax(w, replies)
+		return true
+	}
+	sum := 0
+	for _, reply := range replies {
+		n, ok := reply.tv.Val.(int)
+		if !ok {
+			return false
+		}
+		sum += n
+	}
+	if raw != nil {
+		_, _ = fmt.Fprintf(w, "%d\n", sum)
+	} else {
+		_, _ = fmt.Fprintf(w, "total of %d nodes: (integer) %d\n", len(replies), sum)
+	}
+	return true
+}
+
+// numeric INFO fields which differ between nodes are shown as min and max with the node having it,
+// other fields are shown as is if all nodes agree, or as "(differs)"
+func printInfoMinMax(w io.Writer, replies []nodeReply) {
+	type field struct {
+		value            string
+		numeric, differs bool
+		min, max         float64
+		minAddr, maxAddr string
+	}
+	var order []string
+	fields := map[string]*field{}
+	sections := map[string]bool{}
+	for _, reply := range replies {
+		for _, line := range strings.Split(reply.tv.String(), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "#") {
+				if !sections[line] {
+					sections[line] = true
+					order = append(order, line)
+				}
+				continue
+			}
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			f := fields[key]
+			if f == nil {
+				f = &field{value: value, numeric: true, min: math.Inf(1), max: math.Inf(-1)}
+				fields[key] = f
+				order = append(order, key)
+			}
+			if value != f.value {
+				f.differs = true
+			}
+			num, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				f.numeric = false
+				continue
+			}
+			if num < f.min {
+				f.min, f.minAddr = num, reply.addr
+			}
+			if num > f.max {
+				f.max, f.maxAddr = num, reply.addr
+			}
+		}
+	}
+	for _, key := range order {
+		f := fields[key]
+		switch {
+		case f == nil:
+			_, _ = fmt.Fprintf(w, "%s\n", key)
+		case !f.differs:
+			_, _ = fmt.Fprintf(w, "%s:%s\n", key, f.value)
+		case f.numeric:
+			_, _ = fmt.Fprintf(w, "%s:min=%s (%s),max=%s (%s)\n", key,
+				strconv.FormatFloat(f.min, 'f', -1, 64), f.minAddr, strconv.FormatFloat(f.max, 'f', -1, 64), f.maxAddr)
+		default:
+			_, _ = fmt.Fprintf(w, "%s:(differs)\n", key)
+		}
+	}
+}
+
+// --cluster call host:port command [args...], on all nodes unless --cluster-only-masters or --cluster-only-replicas,
+// --cluster-aggregate runs on masters only, since replicas hold the same keys
+func clusterManagerCall(argv []string) int {
+	if len(argv) < 2 {
+		fmt.Println("[ERR] Wrong number of arguments for specified --cluster sub command")
+		return 1
+	}
+	if clusterOpts.Aggregate && clusterOpts.OnlyReplicas {
+		fmt.Println("[ERR] --cluster-aggregate runs on masters only, it can't be used with --cluster-only-replicas")
+		return 1
+	}
+	onlyMasters := clusterOpts.OnlyMasters || clusterOpts.Aggregate
+	cv, err := loadClusterFromArgs(argv[:1])
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer cv.close()
+	var targets []callTarget
+	for _, node := range cv.nodes {
+		if (onlyMasters && !node.isMaster()) || (clusterOpts.OnlyReplicas && node.isMaster()) {
+			continue
+		}
+		target := callTarget{addr: node.addr, conn: node.conn}
+		// nodes not connected are reported as failed, like :all does
+		switch {
+		case node.unreachable != nil:
+			target.err = node.unreachable
+		case node.conn == nil:
+			target.err = errors.New("node is in fail state")
+		}
+		targets = append(targets, target)
+	}
+	command := argv[1:]
+	replies := fanOut(targets, command, clusterOpts.Aggregate)
+	if err := printFanOut(os.Stdout, command, replies, clusterOpts.Aggregate, cv.seed.conn.rawOutput()); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	return 0
+}
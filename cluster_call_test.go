@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func intReply(addr string, n int) nodeReply {
+	return nodeReply{addr: addr, tv: &TypedVal{Type: TypeInt, Val: n}}
+}
+
+func keysReply(addr string, keys ...string) nodeReply {
+	items := make([]*TypedVal, len(keys))
+	for i, key := range keys {
+		items[i] = &TypedVal{Type: TypeBulkString, Val: key}
+	}
+	return nodeReply{addr: addr, tv: &TypedVal{Type: TypeArray, Val: items}}
+}
+
+func TestPrintAggregate(t *testing.T) {
+	raw := &rawFormat{bulk: "\n", response: "\n"}
+	tests := []struct {
+		name    string
+		argv    []string
+		replies []nodeReply
+		raw     *rawFormat
+		want    string
+		ok      bool
+	}{
+		{
+			name:    "sum",
+			argv:    []string{"DBSIZE"},
+			replies: []nodeReply{intReply("a:1", 3), intReply("b:2", 4)},
+			want:    "total of 2 nodes: (integer) 7\n",
+			ok:      true,
+		},
+		{
+			name:    "sum raw",
+			argv:    []string{"dbsize"},
+			replies: []nodeReply{intReply("a:1", 3), intReply("b:2", 4)},
+			raw:     raw,
+			want:    "7\n",
+			ok:      true,
+		},
+		{
+			name:    "no replies",
+			argv:    []string{"dbsize"},
+			replies: nil,
+			want:    "total of 0 nodes: (integer) 0\n",
+			ok:      true,
+		},
+		{
+			name:    "scan keys merged",
+			argv:    []string{"scan", "0", "MATCH", "user:*"},
+			replies: []nodeReply{keysReply("a:1", "user:1", "user:2"), keysReply("b:2", "user:3")},
+			raw:     raw,
+			want:    "user:1\nuser:2\nuser:3\n",
+			ok:      true,
+		},
+		{
+			name:    "not integers",
+			argv:    []string{"get", "k"},
+			replies: []nodeReply{intReply("a:1", 1), {addr: "b:2", tv: &TypedVal{Type: TypeBulkString, Val: "v"}}},
+			want:    "",
+			ok:      false,
+		},
+	}
+	for _, tt := range tests {
+		var sb strings.Builder
+		ok := printAggregate(&sb, tt.argv, tt.replies, tt.raw)
+		if ok != tt.ok || sb.String() != tt.want {
+			t.Errorf("printAggregate(%s) = %v, %q, want %v, %q", tt.name, ok, sb.String(), tt.ok, tt.want)
+		}
+	}
+}
+
+func TestPrintInfoMinMax(t *testing.T) {
+	info := func(addr, text string) nodeReply {
+		return nodeReply{addr: addr, tv: &TypedVal{Type: TypeVerbatim, Val: text}}
+	}
+	replies := []nodeReply{
+		info("a:1", "# Server\r\nredis_version:7.2.0\r\nrole:master\r\n# Memory\r\nused_memory:100\r\nmem_fragmentation_ratio:1.5\r\n"),
+		info("b:2", "# Server\r\nredis_version:7.2.0\r\nrole:slave\r\n# Memory\r\nused_memory:300\r\nmem_fragmentation_ratio:1.5\r\n"),
+		info("c:3", "# Server\r\nredis_version:7.2.0\r\nrole:master\r\n# Memory\r\nused_memory:50\r\nmem_fragmentation_ratio:1.5\r\nextra:1\r\n"),
+	}
+	want := "# Server\n" +
+		"redis_version:7.2.0\n" +
+		"role:(differs)\n" +
+		"# Memory\n" +
+		"used_memory:min=50 (c:3),max=300 (b:2)\n" +
+		"mem_fragmentation_ratio:1.5\n" +
+		"extra:1\n"
+	var sb strings.Builder
+	printInfoMinMax(&sb, replies)
+	if got := sb.String(); got != want {
+		t.Errorf("printInfoMinMax =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPrintFanOut(t *testing.T) {
+	replies := []nodeReply{
+		intReply("a:1", 3),
+		{addr: "b:2", err: errors.New("[ERR] Could not connect to b:2")},
+		{addr: "c:3", tv: &TypedVal{Type: TypeError, Val: "LOADING Redis is loading the dataset in memory"}},
+		intReply("d:4", 4),
+	}
+	tests := []struct {
+		aggregate bool
+		want      string
+	}{
+		{
+			aggregate: false,
+			want: ">>> Calling dbsize\n" +
+				"b:2: [ERR] Could not connect to b:2\n" +
+				"c:3: (error) LOADING Redis is loading the dataset in memory\n" +
+				"a:1: (integer) 3\n" +
+				"d:4: (integer) 4\n",
+		},
+		{
+			aggregate: true,
+			want: ">>> Calling dbsize\n" +
+				"b:2: [ERR] Could not connect to b:2\n" +
+				"c:3: (error) LOADING Redis is loading the dataset in memory\n" +
+				"total of 2 nodes: (integer) 7\n",
+		},
+	}
+	for _, tt := range tests {
+		var sb strings.Builder
+		err := printFanOut(&sb, []string{"dbsize"}, replies, tt.aggregate, nil)
+		if err == nil || err.Error() != "2 of 4 nodes failed" {
+			t.Errorf("printFanOut(aggregate %v) error = %v, want 2 of 4 nodes failed", tt.aggregate, err)
+		}
+		if got := sb.String(); got != tt.want {
+			t.Errorf("printFanOut(aggregate %v) =\n%s\nwant\n%s", tt.aggregate, got, tt.want)
+		}
+	}
+}
+
+func TestFanOutUnreachable(t *testing.T) {
+	unreachable := errors.New("[ERR] Could not connect to b:2")
+	replies := fanOut([]callTarget{{addr: "b:2", err: unreachable}}, []string{"ping"}, false)
+	if len(replies) != 1 || replies[0].addr != "b:2" || replies[0].err != unreachable {
+		t.Errorf("fanOut(unreachable) = %+v, want failed reply of b:2", replies)
+	}
+}
@@ -0,0 +1,738 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// node of cluster, as seen by the cluster manager (--cluster)
+type clusterNode struct {
+	conn      *Connection
+	addr      string // host:port
+	id        string
+	flags     []string
+	masterId  string // id of master if node is a replica
+	linkState string
+	slots     []int
+	migrating map[int]string // slot -> node id it's migrating to
+	importing map[int]string // slot -> node id it's importing from
+	replicas  []*clusterNode
+	dbsize    int
+	signature string // slots config seen by this node, to check if all nodes agree
+	// node couldn't be connected or queried, it's kept as the seed sees it
+	unreachable error
+}
+
+func (n *clusterNode) hasFlag(flag string) bool {
+	for _, f := range n.flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func removeFlag(flags []string, flag string) []string {
+	var res []string
+	for _, f := range flags {
+		if f != flag {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+func (n *clusterNode) isMaster() bool {
+	return n.hasFlag("master")
+}
+
+// short id shown in reports, like redis-cli
+func (n *clusterNode) shortId() string {
+	if len(n.id) > 8 {
+		return n.id[:8]
+	}
+	return n.id
+}
+
+// all nodes of a cluster, loaded from a seed node
+type clusterView struct {
+	args     *Args
+	seed     *clusterNode
+	nodes    []*clusterNode
+	pass     string // password shared by all nodes, --askpass is asked only once
+	passRead bool
+}
+
+// options of cluster manager commands, given after --cluster <command>
+type clusterOptions struct {
+	Replicas            int     `flag:"cluster-replicas" default:"0"`
+	Slots               int     `flag:"cluster-slots" default:"0"`
+	From                string  `flag:"cluster-from"`
+	To                  string  `flag:"cluster-to"`
+	Yes                 bool    `flag:"cluster-yes"`
+	Timeout             int     `flag:"cluster-timeout" default:"60000"`
+	Pipeline            int     `flag:"cluster-pipeline" default:"10"`
+	Weight              string  `flag:"cluster-weight"`
+	UseEmptyMasters     bool    `flag:"cluster-use-empty-masters"`
+	Threshold           float64 `flag:"cluster-threshold" default:"2"`
+	Replace             bool    `flag:"cluster-replace"`
+	Slave               bool    `flag:"cluster-slave"`
+	Replica             bool    `flag:"cluster-replica"`
+	MasterId            string  `flag:"cluster-master-id"`
+	SearchMultipleOwner bool    `flag:"cluster-search-multiple-owners"`
+	OnlyMasters         bool    `flag:"cluster-only-masters"`
+	OnlyReplicas        bool    `flag:"cluster-only-replicas"`
+	Aggregate           bool    `flag:"cluster-aggregate"`
+	DryRun              bool    `flag:"dry-run"`
+}
+
+var clusterOpts = &clusterOptions{}
+
+// --cluster <command> [args...] [opts...], returns exit code
+func clusterManager(command string, argv []string) int {
+	argv, err := parseClusterOptions(clusterOpts, argv)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	switch strings.ToLower(command) {
+	case "info":
+		return clusterManagerInfo(argv)
+	case "check":
+		return clusterManagerCheck(argv)
+	case "nodes":
+		return clusterManagerNodes(argv)
+	case "slots":
+		return clusterManagerSlots(argv)
+	case "create":
+		return clusterManagerCreate(argv)
+	case "add-node":
+		return clusterManagerAddNode(argv)
+	case "del-node":
+		return clusterManagerDelNode(argv)
+	case "reshard":
+		return clusterManagerReshard(argv)
+	case "rebalance":
+		return clusterManagerRebalance(argv)
+	case "fix":
+		return clusterManagerFix(argv)
+	case "call":
+		return clusterManagerCall(argv)
+	case "help":
+		clusterManagerHelp()
+		return 0
+	default:
+		fmt.Printf("Unknown --cluster subcommand '%s'\n", command)
+		clusterManagerHelp()
+		return 1
+	}
+}
+
+func clusterManagerHelp() {
+	fmt.Println(`Cluster Manager Commands:
+  create         host1:port1 ... hostN:portN
+                 --cluster-replicas <arg>
+  check          host:port
+  info           host:port
+  nodes          host:port
+  slots          host:port
+  fix            host:port
+                 --cluster-search-multiple-owners
+  reshard        host:port
+                 --cluster-from <arg>
+                 --cluster-to <arg>
+                 --cluster-slots <arg>
+                 --cluster-yes
+                 --cluster-timeout <arg>
+                 --cluster-pipeline <arg>
+                 --cluster-replace
+  rebalance      host:port
+                 --cluster-weight <node1=w1...nodeN=wN>
+                 --cluster-use-empty-masters
+                 --cluster-timeout <arg>
+                 --cluster-pipeline <arg>
+                 --cluster-threshold <arg>
+                 --cluster-replace
+  add-node       new_host:new_port existing_host:existing_port
+                 --cluster-slave
+                 --cluster-master-id <arg>
+  del-node       host:port node_id
+  call           host:port command arg arg .. arg
+                 --cluster-only-masters
+                 --cluster-only-replicas
+                 --cluster-aggregate
+  help
+
+For check, exit code is 1 if the cluster is not healthy.
+For create, fix, reshard, rebalance, add-node and del-node, --dry-run shows
+the plan and the commands it would send, without changing the cluster.
+For reshard and rebalance, --cluster-from and --cluster-to accept node ids,
+and --cluster-from accepts "all" or a comma separated list of ids.
+For call, --cluster-aggregate runs on masters only, sums integer replies, merges SCAN
+of all masters into one stream of keys, and shows min/max of INFO fields which differ
+between nodes.
+For rebalance, weights are given by node id (or its prefix), like --cluster-weight a1b2=2 c3d4=1.
+For create and add-node, --cluster-timeout also limits the wait for nodes to join the cluster.`)
+}
+
+// parse cluster manager options mixed with positional arguments, like
+// "create 127.0.0.1:7000 ... --cluster-replicas 1", and return positional arguments
+func parseClusterOptions(ptr any, argv []string) ([]string, error) {
+	fieldsMap := map[string]*fv{}
+	forEachExportedField(ptr, func(f reflect.StructField, v reflect.Value) {
+		fieldsMap[f.Tag.Get("flag")] = &fv{f: f, v: v, set: false}
+	})
+	var rest []string
+	for i := 0; i < len(argv); i++ {
+		a := argv[i]
+		if !strings.HasPrefix(a, "--") {
+			rest = append(rest, a)
+			continue
+		}
+		fv, ok := fieldsMap[strings.TrimPrefix(a, "--")]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("Unrecognized option or bad number of args for: '%s'", a)
+		case fv.f.Type.Kind() == reflect.Bool:
+			fv.SetValue("")
+		case fv.f.Name == "Weight":
+			// weights are listed until the next option
+			var weights []string
+			for i+1 < len(argv) && !strings.HasPrefix(argv[i+1], "--") {
+				i++
+				weights = append(weights, argv[i])
+			}
+			fv.SetValue(strings.Join(weights, " "))
+		case i+1 < len(argv):
+			fv.SetValue(argv[i+1])
+			i++
+		default:
+			return nil, fmt.Errorf("Unrecognized option or bad number of args for: '%s'", a)
+		}
+	}
+	for _, fv := range fieldsMap {
+		if !fv.set {
+			if defVal := fv.f.Tag.Get("default"); defVal != "" {
+				fv.SetValue(defVal)
+			}
+		}
+	}
+	return rest, nil
+}
+
+// load cluster from "host:port" or "host port" arguments
+func loadClusterFromArgs(argv []string) (*clusterView, error) {
+	var addr string
+	switch {
+	case len(argv) == 1:
+		addr = argv[0]
+	case len(argv) == 2 && !strings.Contains(argv[0], ":"):
+		addr = argv[0] + ":" + argv[1]
+	default:
+		return nil, errors.New("[ERR] Wrong number of arguments for specified --cluster sub command")
+	}
+	host, port, err := parseHostPort(addr, 6379)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Invalid address %s", addr)
+	}
+	return loadCluster(args, host, port)
+}
+
+// connect to seed node, then every node it knows
+func loadCluster(args *Args, host string, port int) (*clusterView, error) {
+	cv := &clusterView{args: args}
+	seed, err := cv.connectNode(host, port)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := seed.clusterNodes()
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		node := parseClusterNodeLine(line)
+		if node == nil {
+			continue
+		}
+		if node.hasFlag("myself") {
+			seed.mergeFrom(node)
+			cv.seed = seed
+			cv.nodes = append(cv.nodes, seed)
+			continue
+		}
+		if node.hasFlag("noaddr") || node.hasFlag("handshake") {
+			continue
+		}
+		if node.hasFlag("fail") {
+			// keep failed node in view, but don't connect to it
+			cv.nodes = append(cv.nodes, node)
+			continue
+		}
+		nhost, nport, err := parseHostPort(node.addr, 6379)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := cv.connectNode(nhost, nport)
+		if err == nil {
+			if _, err = conn.loadSelf(); err != nil {
+				_ = conn.conn.Close()
+			}
+		}
+		if err != nil {
+			// report it rather than give up, finding such nodes is what check is for
+			node.unreachable = err
+			cv.nodes = append(cv.nodes, node)
+			continue
+		}
+		cv.nodes = append(cv.nodes, conn)
+	}
+	if cv.seed == nil {
+		return nil, fmt.Errorf("[ERR] Node %s is not configured as a cluster node", joinHostPort(host, port))
+	}
+	for _, node := range cv.nodes {
+		if node.conn != nil && node.isMaster() {
+			if tv, err := node.conn.ExecArgs("DBSIZE"); err == nil && !tv.IsError() {
+				node.dbsize, _ = tv.Val.(int)
+			}
+		}
+	}
+	for _, node := range cv.nodes {
+		if !node.isMaster() {
+			if master := cv.nodeById(node.masterId); master != nil {
+				master.replicas = append(master.replicas, node)
+			}
+		}
+	}
+	return cv, nil
+}
+
+func (cv *clusterView) connectNode(host string, port int) (*clusterNode, error) {
+	nargs := *cv.args
+	nargs.ClusterMode, nargs.Sentinel, nargs.Db = false, "", 0
+	conn := NewConnection(&nargs)
+	conn.host, conn.port, conn.socket = host, port, ""
+	if cv.passRead {
+		conn.pass, conn.passRead = cv.pass, true
+	}
+	if err := conn.Connect(); err != nil {
+		return nil, fmt.Errorf("[ERR] Could not connect to %s", joinHostPort(host, port))
+	}
+	cv.pass, cv.passRead = conn.password(), true
+	return &clusterNode{conn: conn, addr: joinHostPort(host, port)}, nil
+}
+
+func (cv *clusterView) close() {
+	for _, node := range cv.nodes {
+		if node.conn != nil {
+			_ = node.conn.Close()
+		}
+	}
+}
+
+func (cv *clusterView) unreachableNodes() []*clusterNode {
+	var res []*clusterNode
+	for _, node := range cv.nodes {
+		if node.unreachable != nil {
+			res = append(res, node)
+		}
+	}
+	return res
+}
+
+// commands changing the cluster need all nodes
+func (cv *clusterView) requireReachable() error {
+	if nodes := cv.unreachableNodes(); len(nodes) > 0 {
+		return fmt.Errorf("%s\n*** Please fix your cluster problems first", nodes[0].unreachable.Error())
+	}
+	return nil
+}
+
+func (cv *clusterView) nodeById(id string) *clusterNode {
+	for _, node := range cv.nodes {
+		if node.id == id {
+			return node
+		}
+	}
+	return nil
+}
+
+func (cv *clusterView) masters() []*clusterNode {
+	var res []*clusterNode
+	for _, node := range cv.nodes {
+		if node.isMaster() {
+			res = append(res, node)
+		}
+	}
+	return res
+}
+
+// lines of CLUSTER NODES, and slots config signature of this node
+func (n *clusterNode) clusterNodes() ([]string, error) {
+	tv, err := n.conn.ExecArgs("CLUSTER", "NODES")
+	if err != nil {
+		return nil, err
+	}
+	if tv.IsError() {
+		return nil, fmt.Errorf("[ERR] Node %s %s", n.addr, tv.Val)
+	}
+	lines := strings.Split(strings.TrimSpace(tv.String()), "\n")
+	n.signature = configSignature(lines)
+	return lines, nil
+}
+
+// load id, flags and slots of node itself, from its own CLUSTER NODES
+func (n *clusterNode) loadSelf() ([]string, error) {
+	lines, err := n.clusterNodes()
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if node := parseClusterNodeLine(line); node != nil && node.hasFlag("myself") {
+			n.mergeFrom(node)
+			// only the seed is "myself" in the view
+			n.flags = removeFlag(n.flags, "myself")
+			return lines, nil
+		}
+	}
+	return nil, fmt.Errorf("[ERR] Node %s is not configured as a cluster node", n.addr)
+}
+
+func (n *clusterNode) mergeFrom(parsed *clusterNode) {
+	n.id = parsed.id
+	n.flags = parsed.flags
+	n.masterId = parsed.masterId
+	n.linkState = parsed.linkState
+	n.slots = parsed.slots
+	n.migrating = parsed.migrating
+	n.importing = parsed.importing
+}
+
+// <id> <ip:port@cport[,hostname]> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> <slot> ...
+func parseClusterNodeLine(line string) *clusterNode {
+	fields := strings.Fields(line)
+	if len(fields) < 8 {
+		return nil
+	}
+	addr, _, _ := strings.Cut(fields[1], "@")
+	node := &clusterNode{
+		id:        fields[0],
+		addr:      addr,
+		flags:     strings.Split(fields[2], ","),
+		linkState: fields[7],
+		migrating: map[int]string{},
+		importing: map[int]string{},
+	}
+	if fields[3] != "-" {
+		node.masterId = fields[3]
+	}
+	for _, s := range fields[8:] {
+		if strings.HasPrefix(s, "[") {
+			// [slot->-node] migrating, [slot-<-node] importing
+			s = strings.Trim(s, "[]")
+			if slot, id, ok := strings.Cut(s, "->-"); ok {
+				n, _ := strconv.Atoi(slot)
+				node.migrating[n] = id
+			} else if slot, id, ok := strings.Cut(s, "-<-"); ok {
+				n, _ := strconv.Atoi(slot)
+				node.importing[n] = id
+			}
+			continue
+		}
+		start, end, found := strings.Cut(s, "-")
+		from, _ := strconv.Atoi(start)
+		to := from
+		if found {
+			to, _ = strconv.Atoi(end)
+		}
+		for slot := from; slot <= to; slot++ {
+			node.slots = append(node.slots, slot)
+		}
+	}
+	return node
+}
+
+// slots owned by every node as seen by this node, sorted so it can be compared between nodes
+func configSignature(lines []string) string {
+	var items []string
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 9 {
+			continue
+		}
+		var slots []string
+		for _, s := range fields[8:] {
+			if !strings.HasPrefix(s, "[") {
+				slots = append(slots, s)
+			}
+		}
+		if len(slots) > 0 {
+			sort.Strings(slots)
+			items = append(items, fields[0]+":"+strings.Join(slots, ","))
+		}
+	}
+	sort.Strings(items)
+	return strings.Join(items, "|")
+}
+
+// group slots into [start, end] ranges
+func slotRanges(slots []int) [][2]int {
+	sorted := append([]int(nil), slots...)
+	sort.Ints(sorted)
+	var ranges [][2]int
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+			j++
+		}
+		ranges = append(ranges, [2]int{sorted[i], sorted[j]})
+		i = j + 1
+	}
+	return ranges
+}
+
+// format slots as ranges, like "0-5460,10923"
+func formatSlotRanges(slots []int) string {
+	var ranges []string
+	for _, r := range slotRanges(slots) {
+		if r[0] == r[1] {
+			ranges = append(ranges, strconv.Itoa(r[0]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", r[0], r[1]))
+		}
+	}
+	return strings.Join(ranges, ",")
+}
+
+// 127.0.0.1:7000 (b5a3a8ea...) -> 0 keys | 5461 slots | 1 slaves.
+func (cv *clusterView) showInfo() {
+	keys, masters := 0, 0
+	for _, node := range cv.masters() {
+		if node.unreachable != nil {
+			fmt.Printf("%s (%s...) -> unreachable | %d slots | %d slaves.\n",
+				node.addr, node.shortId(), len(node.slots), len(node.replicas))
+			continue
+		}
+		if node.conn == nil {
+			continue
+		}
+		fmt.Printf("%s (%s...) -> %d keys | %d slots | %d slaves.\n",
+			node.addr, node.shortId(), node.dbsize, len(node.slots), len(node.replicas))
+		keys += node.dbsize
+		masters++
+	}
+	fmt.Printf("[OK] %d keys in %d masters.\n", keys, masters)
+	fmt.Printf("%.2f keys per slot on average.\n", float64(keys)/clusterSlots)
+}
+
+// M: / S: listing of nodes with their slots, like redis-cli
+func (cv *clusterView) showNodes() {
+	for _, node := range cv.nodes {
+		role := "slave"
+		prefix := "S"
+		if node.isMaster() {
+			role, prefix = "master", "M"
+		}
+		fmt.Printf("%s: %s %s\n", prefix, node.id, node.addr)
+		if len(node.slots) > 0 {
+			fmt.Printf("   slots:[%s] (%d slots) %s\n", formatSlotRanges(node.slots), len(node.slots), role)
+		} else {
+			fmt.Printf("   slots: (0 slots) %s\n", role)
+		}
+		if node.isMaster() && len(node.replicas) > 0 {
+			fmt.Printf("   %d additional replica(s)\n", len(node.replicas))
+		}
+		if node.masterId != "" {
+			fmt.Printf("   replicates %s\n", node.masterId)
+		}
+	}
+}
+
+// check slots config, open slots and coverage, returns false if any error found
+func (cv *clusterView) check() bool {
+	ok := true
+	fmt.Printf(">>> Performing Cluster Check (using node %s)\n", cv.seed.addr)
+	cv.showNodes()
+
+	if cv.configConsistent() {
+		fmt.Println("[OK] All nodes agree about slots configuration.")
+	} else {
+		fmt.Println("[ERR] Nodes don't agree about configuration!")
+		ok = false
+	}
+
+	for _, node := range cv.nodes {
+		switch {
+		case node.hasFlag("fail"):
+			fmt.Printf("[ERR] Node %s is in fail state.\n", node.addr)
+			ok = false
+		case node.hasFlag("fail?"):
+			fmt.Printf("[WARNING] Node %s is possibly failing.\n", node.addr)
+		}
+		if node.unreachable != nil {
+			fmt.Printf("[ERR] Node %s is unreachable: %s\n", node.addr, strings.TrimPrefix(node.unreachable.Error(), "[ERR] "))
+			ok = false
+		}
+	}
+	for _, node := range cv.masters() {
+		if len(node.slots) > 0 && len(node.replicas) == 0 {
+			fmt.Printf("[WARNING] Master %s has no replicas.\n", node.addr)
+		}
+	}
+
+	fmt.Println(">>> Check for open slots...")
+	for _, node := range cv.nodes {
+		if len(node.migrating) > 0 {
+			fmt.Printf("[WARNING] Node %s has slots in migrating state %s.\n", node.addr, formatSlotRanges(mapKeys(node.migrating)))
+		}
+		if len(node.importing) > 0 {
+			fmt.Printf("[WARNING] Node %s has slots in importing state %s.\n", node.addr, formatSlotRanges(mapKeys(node.importing)))
+		}
+	}
+	if open := cv.openSlots(); len(open) > 0 {
+		fmt.Printf("[WARNING] The following slots are open: %s.\n", formatSlotRanges(open))
+		ok = false
+	}
+
+	fmt.Println(">>> Check slots coverage...")
+	if uncovered := cv.uncoveredSlots(); len(uncovered) == 0 {
+		fmt.Printf("[OK] All %d slots covered.\n", clusterSlots)
+	} else {
+		fmt.Printf("[ERR] Not all %d slots are covered by nodes.\n", clusterSlots)
+		ok = false
+	}
+	return ok
+}
+
+// slots in migrating or importing state on any node
+func (cv *clusterView) openSlots() []int {
+	open := map[int]bool{}
+	for _, node := range cv.nodes {
+		for slot := range node.migrating {
+			open[slot] = true
+		}
+		for slot := range node.importing {
+			open[slot] = true
+		}
+	}
+	slots := mapKeys(open)
+	sort.Ints(slots)
+	return slots
+}
+
+func (cv *clusterView) configConsistent() bool {
+	signature := ""
+	for _, node := range cv.nodes {
+		if node.conn == nil {
+			continue
+		}
+		if signature == "" {
+			signature = node.signature
+		} else if node.signature != signature {
+			return false
+		}
+	}
+	return true
+}
+
+func (cv *clusterView) slotOwners() [clusterSlots]*clusterNode {
+	var owners [clusterSlots]*clusterNode
+	for _, node := range cv.masters() {
+		for _, slot := range node.slots {
+			owners[slot] = node
+		}
+	}
+	return owners
+}
+
+func (cv *clusterView) uncoveredSlots() []int {
+	var res []int
+	for slot, owner := range cv.slotOwners() {
+		if owner == nil {
+			res = append(res, slot)
+		}
+	}
+	return res
+}
+
+func mapKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func clusterManagerInfo(argv []string) int {
+	cv, err := loadClusterFromArgs(argv)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer cv.close()
+	cv.showInfo()
+	if len(cv.unreachableNodes()) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func clusterManagerCheck(argv []string) int {
+	cv, err := loadClusterFromArgs(argv)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer cv.close()
+	cv.showInfo()
+	if !cv.check() {
+		return 1
+	}
+	return 0
+}
+
+// one line per node: id, address, role, master, slots, keys, link state
+func clusterManagerNodes(argv []string) int {
+	cv, err := loadClusterFromArgs(argv)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer cv.close()
+	for _, node := range cv.nodes {
+		role, master := "slave", node.masterId
+		if node.isMaster() {
+			role, master = "master", "-"
+		}
+		fmt.Printf("%s %s %s %s %d slots %d keys %s %s\n", node.id, node.addr, role, master,
+			len(node.slots), node.dbsize, node.linkState, strings.Join(node.flags, ","))
+	}
+	return 0
+}
+
+// slot ranges and the master owning them, uncovered ranges are shown too
+func clusterManagerSlots(argv []string) int {
+	cv, err := loadClusterFromArgs(argv)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer cv.close()
+	owners := cv.slotOwners()
+	for start := 0; start < clusterSlots; {
+		end := start
+		for end+1 < clusterSlots && owners[end+1] == owners[start] {
+			end++
+		}
+		if owner := owners[start]; owner != nil {
+			fmt.Printf("%d-%d %s (%s...)\n", start, end, owner.addr, owner.shortId())
+		} else {
+			fmt.Printf("%d-%d [uncovered]\n", start, end)
+		}
+		start = end + 1
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseClusterNodeLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want *clusterNode
+	}{
+		{
+			line: "07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004,host4 slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317239 4 connected",
+			want: &clusterNode{
+				id: "07c37dfeb235213a872192d90877d0cd55635b91", addr: "127.0.0.1:30004", flags: []string{"slave"},
+				masterId: "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca", linkState: "connected",
+			},
+		},
+		{
+			line: "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001 myself,master - 0 0 1 connected 0-2 5 [3->-292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f] [4-<-67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1]",
+			want: &clusterNode{
+				id: "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca", addr: "127.0.0.1:30001", flags: []string{"myself", "master"},
+				linkState: "connected", slots: []int{0, 1, 2, 5},
+				migrating: map[int]string{3: "292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f"},
+				importing: map[int]string{4: "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1"},
+			},
+		},
+		{
+			line: "292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f [::1]:30002@31002 master,fail - 1426238316232 1426238314232 2 disconnected 16383",
+			want: &clusterNode{
+				id: "292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f", addr: "[::1]:30002", flags: []string{"master", "fail"},
+				linkState: "disconnected", slots: []int{16383},
+			},
+		},
+		{line: ""},
+		{line: "07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004 slave"},
+	}
+	for _, tt := range tests {
+		got := parseClusterNodeLine(tt.line)
+		if tt.want == nil {
+			if got != nil {
+				t.Errorf("parseClusterNodeLine(%q) = %+v, want nil", tt.line, got)
+			}
+			continue
+		}
+		if tt.want.migrating == nil {
+			tt.want.migrating = map[int]string{}
+		}
+		if tt.want.importing == nil {
+			tt.want.importing = map[int]string{}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseClusterNodeLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestConfigSignature(t *testing.T) {
+	a := []string{
+		"aaaa 127.0.0.1:7000@17000 myself,master - 0 0 1 connected 0-5460",
+		"bbbb 127.0.0.1:7001@17001 master - 0 0 2 connected 10923-16383 5461-10922",
+		"cccc 127.0.0.1:7002@17002 slave aaaa 0 0 1 connected",
+	}
+	// seen by another node: other flags and order, and a migration in progress
+	b := []string{
+		"cccc 127.0.0.1:7002@17002 myself,slave aaaa 0 0 1 connected",
+		"bbbb 127.0.0.1:7001@17001 master - 0 1 2 connected 5461-10922 10923-16383 [5461->-aaaa]",
+		"aaaa 127.0.0.1:7000@17000 master - 0 1 1 connected 0-5460",
+	}
+	// slot 5460 moved to bbbb
+	c := []string{
+		"aaaa 127.0.0.1:7000@17000 myself,master - 0 0 1 connected 0-5459",
+		"bbbb 127.0.0.1:7001@17001 master - 0 0 2 connected 5460-10922 10923-16383",
+	}
+	want := "aaaa:0-5460|bbbb:10923-16383,5461-10922"
+	if got := configSignature(a); got != want {
+		t.Errorf("configSignature(a) = %q, want %q", got, want)
+	}
+	if configSignature(b) != configSignature(a) {
+		t.Errorf("configSignature(b) = %q, want same as a %q", configSignature(b), configSignature(a))
+	}
+	if configSignature(c) == configSignature(a) {
+		t.Errorf("configSignature(c) = %q, want different from a", configSignature(c))
+	}
+	if got := configSignature(nil); got != "" {
+		t.Errorf("configSignature(nil) = %q, want empty", got)
+	}
+}
+
+func TestRequireReachable(t *testing.T) {
+	cv := &clusterView{nodes: []*clusterNode{{addr: "127.0.0.1:7000"}, {addr: "127.0.0.1:7001"}}}
+	if err := cv.requireReachable(); err != nil {
+		t.Errorf("requireReachable() = %v, want nil", err)
+	}
+	cv.nodes[1].unreachable = errors.New("[ERR] Could not connect to 127.0.0.1:7001")
+	if nodes := cv.unreachableNodes(); len(nodes) != 1 || nodes[0] != cv.nodes[1] {
+		t.Errorf("unreachableNodes() = %v, want node 127.0.0.1:7001", nodes)
+	}
+	err := cv.requireReachable()
+	if err == nil || !strings.HasPrefix(err.Error(), "[ERR] Could not connect to 127.0.0.1:7001\n") {
+		t.Errorf("requireReachable() = %v, want error of unreachable node", err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestCrc16(t *testing.T) {
+	// check value of CRC16/XMODEM
+	if got := crc16("123456789"); got != 0x31c3 {
+		t.Errorf("crc16(\"123456789\") = %#x, want 0x31c3", got)
+	}
+}
+
+func TestKeyHashSlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{key: "", want: 0},
+		{key: "foo", want: 12182},
+		{key: "bar", want: 5061},
+		{key: "hello", want: 866},
+		{key: "\xff\x00", want: 1023},
+		// hash tags
+		{key: "user1000", want: 3443},
+		{key: "{user1000}.following", want: 3443},
+		{key: "foo{bar}{zap}", want: 5061},
+		{key: "foo{{bar}}zap", want: keyHashSlot("{bar")},
+		// empty or unclosed tags hash the whole key
+		{key: "foo{}{bar}", want: 8363},
+		{key: "{}", want: 15257},
+		{key: "a{b", want: 13340},
+	}
+	for _, tt := range tests {
+		if got := keyHashSlot(tt.key); got != tt.want {
+			t.Errorf("keyHashSlot(%q) = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+}
@@ -3,150 +3,331 @@ package main
 import (
 	"bufio"
 	"crypto/tls"
-	"crypto/x509"
+	"errors"
 	"fmt"
 	"golang.org/x/term"
 	"io"
-	"log"
 	"net"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // a abstract redis connection
 type Connection struct {
 	args      *Args
+	host      string // server address, from args or resolved by sentinel
+	port      int
+	socket    string
+	resolved  bool     // address has been resolved by sentinel
+	cluster   *Cluster // cluster mode, commands are executed by node connections
 	conn      net.Conn
 	bufReader *bufio.Reader
 	connected bool
 	istty     bool
 	writer    io.Writer
+	resp      int    // protocol version in use, 2 or 3
+	pass      string // password, cached since --askpass can only be asked once
+	passRead  bool
+	tlsConfig *tls.Config // config of current TLS connection, for diagnostics
+	// session state to replay after reconnect, db is kept in args.Db
+	clientName string
+	reconnect  bool // reconnect automatically when connection is lost
+	failed     bool // some command got an error reply, for -e
+	lastActive time.Time
+	subs       map[string]map[string]bool // subscribed channels by subscribe command, forgotten by server on close
+	deadline   time.Time                  // whole run must finish before it, zero means no deadline
+	// last command and its round trip time, metadata of --ndjson records
+	lastCmd     []string
+	lastElapsed time.Duration
+	ndjsonMeta  map[string]bool // fields of --ndjson-meta
+	// a reply is being waited for, and it's aborted by Interrupt
+	inflight    atomic.Bool
+	interrupted atomic.Bool
+	// guards conn and cluster, which Interrupt reads from another goroutine
+	mu sync.Mutex
 }
 
+const (
+	reconnectAttempts = 6
+	reconnectMinDelay = 100 * time.Millisecond
+	reconnectMaxDelay = 3 * time.Second
+)
+
+var ErrNotConnected = errors.New("not connected")
+var ErrServerClosed = errors.New("Error: Server closed the connection")
+var ErrTimeout = errors.New("Error: timed out waiting for reply")
+var ErrDeadline = errors.New("Error: deadline exceeded")
+var ErrInterrupted = errors.New("(interrupted)")
+
 func NewConnection(args *Args) *Connection {
+	resp := 2
+	// --json defaults to RESP3, so maps and sets keep their types
+	if (args.Resp3 || args.Json || args.QuotedJson || args.Ndjson) && !args.Resp2 {
+		resp = 3
+	}
+	socket := args.Socket
+	if args.Sentinel != "" {
+		// address is resolved by sentinel
+		socket = ""
+	}
+	// already validated by main
+	meta, _ := parseNdjsonMeta(args.NdjsonMeta)
 	return &Connection{
-		args:   args,
-		istty:  term.IsTerminal(int(os.Stdout.Fd())),
-		writer: os.Stdout,
+		args:       args,
+		host:       args.Hostname,
+		port:       args.Port,
+		socket:     socket,
+		istty:      term.IsTerminal(int(os.Stdout.Fd())),
+		writer:     os.Stdout,
+		resp:       resp,
+		ndjsonMeta: meta,
 	}
 }
 
 // do connect and auth and select db
 func (c *Connection) Connect() error {
 	_ = c.Close()
-	addr := fmt.Sprintf("%s:%d", c.args.Hostname, c.args.Port)
-	var conn net.Conn
-	var err error
-	if c.args.Tls {
-		conf := c.parseTlsConfig()
-		conn, err = tls.Dial("tcp", addr, conf)
-	} else {
-		conn, err = net.Dial("tcp", addr)
+	if c.args.ClusterMode {
+		return c.connectCluster()
+	}
+	if c.args.Sentinel != "" {
+		// master may be changing during failover, retry until sentinel agrees with the server
+		return c.Reconnect()
 	}
+	conn, err := c.dial()
 	if err != nil {
-		fmt.Printf("Could not connect to Redis at %s: %s\n", addr, err.Error())
 		return err
 	}
-	c.connected = true
-	c.conn = conn
-	c.bufReader = bufio.NewReader(conn)
+	return c.setup(conn)
+}
 
-	err = c.auth()
-	if err == nil {
-		err = c.selectDb()
+// reconnect with exponential backoff, session state is restored by setup
+func (c *Connection) Reconnect() error {
+	if c.args.ClusterMode {
+		// node connections reconnect by themselves, only seed is connected here
+		return c.connectCluster()
 	}
-
-	return nil
+	delay := reconnectMinDelay
+	var err error
+	for i := 0; i < reconnectAttempts; i++ {
+		if i > 0 {
+			time.Sleep(delay)
+			delay = min(delay*2, reconnectMaxDelay)
+		}
+		_ = c.Close()
+		var conn net.Conn
+		if conn, err = c.dial(); err == nil {
+			if err = c.setup(conn); !errors.Is(err, ErrRoleMismatch) {
+				return err
+			}
+		}
+	}
+	return err
 }
 
-func (c *Connection) parseTlsConfig() *tls.Config {
-	config := &tls.Config{
-		ServerName:         c.args.Sni,
-		InsecureSkipVerify: c.args.Insecure,
+func (c *Connection) dial() (net.Conn, error) {
+	if c.args.Sentinel != "" {
+		if err := c.resolveSentinel(); err != nil {
+			_, _ = fmt.Fprintln(c.writer, err.Error())
+			return nil, err
+		}
 	}
-
-	if c.args.Cert != "" {
-		caCert, err := tls.LoadX509KeyPair(c.args.Cert, c.args.Key)
-		if err != nil {
-			log.Fatalf("Failed to load client certificate: %v", err)
+	network, addr := c.address()
+	dialer := &net.Dialer{
+		Timeout:   seconds(c.args.ConnectTimeout),
+		Deadline:  c.deadline,
+		KeepAlive: time.Duration(c.args.Keepalive) * time.Second,
+	}
+	if c.args.Keepalive <= 0 {
+		dialer.KeepAlive = -1
+	}
+	var conn net.Conn
+	var err error
+	if c.args.Tls {
+		var conf *tls.Config
+		if conf, err = c.parseTlsConfig(); err != nil {
+			_, _ = fmt.Fprintf(c.writer, "Could not create TLS context: %s\n", err.Error())
+			return nil, err
+		}
+		if network == "unix" && conf.ServerName == "" {
+			// server name would be taken from socket path, verify certificate against -h instead
+			conf.ServerName = c.host
 		}
-		config.Certificates = []tls.Certificate{caCert}
+		c.tlsConfig = conf
+		conn, err = tls.DialWithDialer(dialer, network, addr, conf)
+	} else {
+		conn, err = dialer.Dial(network, addr)
 	}
-
-	if c.args.Cacert != "" {
-		caCert, err := tls.LoadX509KeyPair(c.args.Cacert, c.args.Key)
-		if err != nil {
-			log.Fatalf("Failed to load CA certificate: %v", err)
+	if err != nil {
+		_, _ = fmt.Fprintf(c.writer, "Could not connect to Redis at %s: %s\n", addr, err.Error())
+		if c.args.Tls && c.args.TlsDebug {
+			c.probeTls(dialer, network, addr, c.tlsConfig)
 		}
-		config.RootCAs.AppendCertsFromPEM(caCert.Certificate[0])
+		return nil, err
 	}
+	return conn, nil
+}
 
-	if c.args.Cacertdir != "" {
-		config.ClientCAs = loadCACertificates(c.args.Cacertdir)
+// init a new connection, replay protocol version, auth, client name and db
+func (c *Connection) setup(conn net.Conn) (err error) {
+	c.connected = true
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	c.bufReader = bufio.NewReader(conn)
+	if c.args.Tls && c.args.TlsDebug {
+		c.PrintTlsInfo()
 	}
 
-	if c.args.TlsCiphers != "" {
-		config.CipherSuites = parseCiphers(c.args.TlsCiphers)
+	if c.resp == 3 {
+		err = c.hello()
+	} else {
+		err = c.auth()
 	}
-
-	if c.args.TlsCiphersuites != "" {
-		config.CipherSuites = parseCipherSuites(c.args.TlsCiphersuites)
+	if err == nil {
+		err = c.setName()
+	}
+	if err == nil {
+		err = c.selectDb()
 	}
-	return config
+	if err == nil && c.args.Sentinel != "" {
+		err = c.verifyRole()
+	}
+	return err
 }
 
-func loadCACertificates(dir string) *x509.CertPool {
-	pool := x509.NewCertPool()
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		log.Fatalf("Failed to read directory: %v", err)
+// network and address to dial, unix socket overrides hostname and port
+func (c *Connection) address() (network, addr string) {
+	if c.socket != "" {
+		return "unix", c.socket
 	}
+	return "tcp", net.JoinHostPort(c.host, strconv.Itoa(c.port))
+}
 
-	for _, file := range files {
-		cert, err := os.ReadFile(filepath.Join(dir, file.Name()))
-		if err != nil {
-			log.Printf("Failed to read certificate file %s: %v", file.Name(), err)
-			continue
+// exec command, if connection is lost and reconnect is enabled,
+// reconnect and send the command again, same as redis-cli
+func (c *Connection) ExecArgs(argv ...string) (*TypedVal, error) {
+	if len(argv) == 0 {
+		return nil, ErrInvalidArgs
+	}
+	start := time.Now()
+	defer func() {
+		c.lastCmd, c.lastElapsed = argv, time.Since(start)
+	}()
+	if c.cluster != nil {
+		return c.cluster.exec(argv)
+	}
+	if !c.connected && c.reconnect {
+		if err := c.Reconnect(); err != nil {
+			return nil, err
+		}
+	}
+	tv, err := c.roundTrip(argv)
+	if errors.Is(err, ErrInterrupted) && c.reconnect {
+		// reply of the aborted command is still on the way, so start over with a new connection
+		_ = c.Reconnect()
+		return nil, err
+	}
+	if err != nil && c.reconnect && isConnErr(err) {
+		if c.Reconnect() != nil {
+			return nil, err
 		}
-		if !pool.AppendCertsFromPEM(cert) {
-			log.Printf("Failed to add certificate from file %s", file.Name())
+		tv, err = c.roundTrip(argv)
+	}
+	if err == nil && c.args.Sentinel != "" && !c.args.SentinelReplica && isReadonlyErr(tv) {
+		// master has been demoted by failover, the command was rejected, so it's safe to send it again
+		if c.Reconnect() == nil {
+			tv, err = c.roundTrip(argv)
 		}
 	}
-
-	return pool
+	return tv, err
 }
 
-func parseCiphers(ciphers string) []uint16 {
-	// Implement parsing logic for TLS ciphers
-	return nil
+func isReadonlyErr(tv *TypedVal) bool {
+	msg, _ := tv.Val.(string)
+	return tv.IsError() && strings.HasPrefix(msg, "READONLY")
 }
 
-func parseCipherSuites(ciphersuites string) []uint16 {
-	// Implement parsing logic for TLS ciphersuites
-	return nil
-}
-
-func (c *Connection) Exec(input string) (*TypedVal, error) {
-	err := c.Send(input)
+// send command and receive reply, connection is closed on any error,
+// since request and reply can't be paired anymore
+func (c *Connection) roundTrip(argv []string) (*TypedVal, error) {
+	if !c.connected {
+		return nil, ErrNotConnected
+	}
+	c.lastActive = time.Now()
+	err := c.Send(argv)
 	if err != nil {
-		c.PrintRawString(err.Error())
+		_ = c.Close()
 		return nil, err
 	}
-	tv, err := c.ReceiveValue()
+	timeout, blocking := c.replyTimeout(argv)
+	start := time.Now()
+	stop := func() {}
+	if blocking {
+		stop = c.waitIndicator(start)
+	}
+	tv, err := c.receive(timeout)
+	for err == nil && tv.Type == TypePush {
+		// indicator is written on the same line, so it's stopped while the push is printed
+		stop()
+		c.handlePush(tv)
+		if blocking {
+			stop = c.waitIndicator(start)
+		}
+		tv, err = c.receive(remaining(start, timeout))
+	}
+	stop()
 	if err != nil {
-		c.PrintRawString(err.Error())
+		_ = c.Close()
 		return nil, err
 	}
-	if tv.Type == TypeError {
-		strings.Fields(tv.Val.(string))
-		// todo
-	}
 	return tv, nil
 }
 
-func (c *Connection) auth() error {
+// connection lost or reset, rather than a protocol error or timeout
+func isConnErr(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return !opErr.Timeout()
+	}
+	return errors.Is(err, ErrServerClosed) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// abort the command waiting for reply, called from another goroutine (e.g. on SIGINT)
+// returns false if no command is in flight
+func (c *Connection) Interrupt() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cluster != nil {
+		if node := c.cluster.active.Load(); node != nil {
+			return node.Interrupt()
+		}
+		interrupted := false
+		if nodes := c.cluster.fanout.Load(); nodes != nil {
+			for _, node := range *nodes {
+				interrupted = node.Interrupt() || interrupted
+			}
+		}
+		return interrupted
+	}
+	if !c.inflight.Load() || c.conn == nil {
+		return false
+	}
+	c.interrupted.Store(true)
+	// wake up the blocking read, conn itself is closed by the reader
+	_ = c.conn.SetReadDeadline(time.Now())
+	return true
+}
+
+// password from --askpass, args or env
+func (c *Connection) password() string {
+	if c.passRead {
+		return c.pass
+	}
 	var pass string
 	if c.args.Askpass {
 		fmt.Print("Please input password: ")
@@ -161,30 +342,91 @@ func (c *Connection) auth() error {
 		// consider password from args or env
 		pass = defaults(c.args.Pass, c.args.Password, os.Getenv("REDISCLI_AUTH"))
 	}
+	c.pass, c.passRead = pass, true
+	return pass
+}
+
+func (c *Connection) auth() error {
+	pass := c.password()
 	if pass == "" {
 		return nil
 	}
-	tv, err := c.Exec(fmt.Sprintf("AUTH %s %s", c.args.User, pass))
+	authArgs := []string{"AUTH", pass}
+	if c.args.User != "" {
+		authArgs = []string{"AUTH", c.args.User, pass}
+	}
+	tv, err := c.roundTrip(authArgs)
 	if err != nil {
 		c.PrintRawString(err.Error())
 		return err
 	}
-	if tv.Type == TypeError {
+	if tv.IsError() {
 		_, _ = fmt.Fprintf(c.writer, "AUTH failed: %s\n", tv.Val)
 	}
 	return nil
 }
 
+// switch to RESP3 with HELLO, AUTH is folded into it
+// fall back to RESP2 if server doesn't support HELLO or RESP3, other errors like WRONGPASS are returned
+func (c *Connection) hello() error {
+	helloArgs := []string{"HELLO", "3"}
+	if pass := c.password(); pass != "" {
+		helloArgs = append(helloArgs, "AUTH", defaults(c.args.User, "default"), pass)
+	}
+	if c.clientName != "" {
+		helloArgs = append(helloArgs, "SETNAME", c.clientName)
+	}
+	tv, err := c.roundTrip(helloArgs)
+	if err != nil {
+		return err
+	}
+	if tv.IsError() {
+		_, _ = fmt.Fprintf(c.writer, "HELLO 3 failed: %s\n", tv.Val)
+		if !helloUnsupported(tv) {
+			// credentials aren't sent again by AUTH
+			return errors.New(tv.String())
+		}
+		c.resp = 2
+		if err = c.auth(); err != nil {
+			return err
+		}
+		return c.setName()
+	}
+	return nil
+}
+
+// HELLO is unknown before redis 6, and NOPROTO is returned if protocol version isn't supported
+func helloUnsupported(tv *TypedVal) bool {
+	msg := tv.String()
+	return strings.HasPrefix(msg, "NOPROTO") || strings.HasPrefix(msg, "ERR unknown command")
+}
+
+// restore client name set by CLIENT SETNAME, HELLO 3 has already done it
+func (c *Connection) setName() error {
+	if c.clientName == "" || c.resp == 3 {
+		return nil
+	}
+	tv, err := c.roundTrip([]string{"CLIENT", "SETNAME", c.clientName})
+	if err != nil {
+		c.PrintRawString(err.Error())
+		return err
+	}
+	if tv.IsError() {
+		_, _ = fmt.Fprintf(c.writer, "CLIENT SETNAME failed: %s\n", tv.Val)
+	}
+	return nil
+}
+
 func (c *Connection) selectDb() error {
 	if c.args.Db == 0 {
 		return nil
 	}
-	tv, err := c.Exec("SELECT " + fmt.Sprint(c.args.Db))
+	tv, err := c.roundTrip([]string{"SELECT", strconv.Itoa(c.args.Db)})
 	if err != nil {
 		c.PrintRawString(err.Error())
 		return err
 	}
-	if tv.Type == TypeError {
+	if tv.IsError() {
 		_, _ = fmt.Fprintf(c.writer, "SELECT %d failed: %s\n", c.args.Db, tv.Val)
 		c.args.Db = 0
 	}
@@ -192,32 +434,99 @@ func (c *Connection) selectDb() error {
 }
 
 func (c *Connection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cluster != nil {
+		c.cluster.close()
+		c.cluster = nil
+	}
 	if c.conn != nil {
 		_ = c.conn.Close()
 	}
 	c.bufReader = nil
 	c.connected = false
+	c.subs = nil
 	return nil
 }
 
-// always send command with \r\n (some redis server may not support \n)
-func (c *Connection) Send(input string) (err error) {
-	_, err = c.conn.Write([]byte(input + "\r\n"))
+// always send command as multi-bulk array, so args can contain spaces, quotes and binary data
+func (c *Connection) Send(argv []string) (err error) {
+	_ = c.conn.SetWriteDeadline(c.deadline)
+	_, err = c.conn.Write(EncodeCommand(argv))
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = ErrDeadline
+	}
 	return
 }
 
+// receive a reply, wait no longer than --timeout and the deadline
 func (c *Connection) ReceiveValue() (*TypedVal, error) {
-	return ReadValue(c.bufReader)
+	return c.receive(seconds(c.args.Timeout))
+}
+
+// time left of timeout started at start, pushes received meanwhile don't extend it
+func remaining(start time.Time, timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return timeout
+	}
+	// receive doesn't limit a non-positive timeout, so an expired one is kept positive
+	return max(time.Until(start.Add(timeout)), time.Nanosecond)
+}
+
+// receive with timeout, 0 means wait forever (still limited by the deadline)
+func (c *Connection) receive(timeout time.Duration) (*TypedVal, error) {
+	readDeadline := c.deadline
+	if timeout > 0 && (readDeadline.IsZero() || time.Now().Add(timeout).Before(readDeadline)) {
+		readDeadline = time.Now().Add(timeout)
+	}
+	_ = c.conn.SetReadDeadline(readDeadline)
+	c.interrupted.Store(false)
+	c.inflight.Store(true)
+	tv, err := ReadValue(c.bufReader)
+	c.inflight.Store(false)
+	switch {
+	case err == nil:
+	case c.interrupted.Load():
+		err = ErrInterrupted
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		if !c.deadline.IsZero() && !time.Now().Before(c.deadline) {
+			err = ErrDeadline
+		} else {
+			err = ErrTimeout
+		}
+	case errors.Is(err, io.EOF):
+		err = ErrServerClosed
+	}
+	return tv, err
 }
 
 // print value with format or not , by args --no-raw
-// and, if not tty, always print in raw format
+// and, if not tty, always print in raw format, or as JSON or CSV by --json, --ndjson and --csv
 func (c *Connection) PrintVal(tv *TypedVal) {
+	switch {
+	case c.jsonOutput():
+		c.printJsonLine(formatJson(tv, c.args.QuotedJson))
+	case c.args.Csv:
+		// one row per reply
+		_, _ = fmt.Fprintln(c.writer, formatCsv(tv))
+	default:
+		PrintVal(c.writer, tv, c.rawOutput())
+	}
+}
+
+func (c *Connection) raw() bool {
 	if c.args.NoRaw {
-		PrintVal(c.writer, tv, false)
-	} else {
-		PrintVal(c.writer, tv, c.args.Raw || !c.istty)
+		return false
 	}
+	return c.args.Raw || !c.istty
+}
+
+// delimiters from -d and -D in raw mode, nil if output is formatted
+func (c *Connection) rawOutput() *rawFormat {
+	if !c.raw() {
+		return nil
+	}
+	return &rawFormat{bulk: c.args.DelimiterBulk, response: c.args.DelimiterResponses}
 }
 
 func (c *Connection) PrintRawString(str string) {
@@ -228,33 +537,80 @@ func (c *Connection) CliPrefix() string {
 	if !c.connected {
 		return "not connected"
 	}
-	addr := fmt.Sprintf("%s:%d", c.args.Hostname, c.args.Port)
+	if c.cluster != nil {
+		return c.cluster.current.CliPrefix()
+	}
+	network, addr := c.address()
+	if network == "unix" {
+		// same as redis-cli: "redis /path/to/redis.sock> "
+		addr = "redis " + addr
+	}
 	if c.args.Db != 0 {
-		return fmt.Sprintf("%s[%d]", addr, c.args.Db)
-	} else {
-		return addr
+		addr = fmt.Sprintf("%s[%d]", addr, c.args.Db)
+	}
+	if c.subscribed() {
+		// RESP3 only, RESP2 connection leaves subscribe mode before returning to the prompt
+		addr += "(subscribed mode)"
 	}
+	return addr
 }
 
 // exec command and print result with format
 func (c *Connection) ExecPrint(input string) error {
-	tv, err := c.Exec(input)
+	argv, err := SplitArgs(input)
+	if err != nil {
+		return err
+	}
+	return c.ExecPrintArgs(argv...)
+}
+
+func (c *Connection) ExecPrintArgs(argv ...string) error {
+	if len(argv) == 0 {
+		return nil
+	}
+	if isPubsubCmd(argv) {
+		return c.ExecSubscribe(argv)
+	}
+	if isCmd(argv, "monitor") {
+		return c.Monitor(argv)
+	}
+	tv, err := c.ExecArgs(argv...)
 	if err != nil {
 		return err
 	}
-	if isCmd(input, "info") {
+	if tv.IsError() {
+		c.failed = true
+	}
+	if isCmd(argv, "info") && !tv.IsError() && !c.jsonOutput() && !c.args.Csv {
 		// always print info command raw string
 		c.PrintRawString(tv.Val.(string))
 	} else {
 		c.PrintVal(tv)
 	}
-	if isCmd(input, "select") && tv.Val.(string) == "OK" {
+	if isCmd(argv, "select") && len(argv) > 1 && tv.Val == "OK" {
 		// update completer prefix
-		c.args.Db, _ = strconv.Atoi(strings.Fields(input)[1])
+		c.args.Db, _ = strconv.Atoi(argv[1])
+	}
+	if isCmd(argv, "client") && len(argv) > 2 && strings.EqualFold(argv[1], "setname") && !tv.IsError() {
+		c.clientName = argv[2]
+	}
+	if isTrackingOn(argv) && !tv.IsError() && c.resp == 2 {
+		_, _ = fmt.Fprintln(c.writer, "Invalidation messages are pushed only to RESP3 connections, use -3 or HELLO 3 to see them")
+	}
+	if isCmd(argv, "hello") && len(argv) > 1 && !tv.IsError() {
+		// keep track of protocol version switched by user
+		if v, err := strconv.Atoi(argv[1]); err == nil {
+			c.resp = v
+		}
 	}
 	return nil
 }
 
+// convert seconds from args, like -i 0.1, to duration
+func seconds(sec float64) time.Duration {
+	return time.Duration(sec * float64(time.Second))
+}
+
 func defaults(str ...string) string {
 	for _, s := range str {
 		if s != "" {
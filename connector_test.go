@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHelloUnsupported(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  bool
+	}{
+		{reply: "NOPROTO unsupported protocol version", want: true},
+		{reply: "ERR unknown command 'HELLO', with args beginning with: '3' ", want: true},
+		{reply: "ERR unknown command `HELLO`, with args beginning with: `3`, ", want: true},
+		{reply: "WRONGPASS invalid username-password pair or user is disabled.", want: false},
+		{reply: "NOPERM this user has no permissions to run the 'hello' command", want: false},
+		{reply: "ERR Protocol version is not an integer or out of range", want: false},
+	}
+	for _, tt := range tests {
+		if got := helloUnsupported(&TypedVal{Type: TypeError, Val: tt.reply}); got != tt.want {
+			t.Errorf("helloUnsupported(%q) = %v, want %v", tt.reply, got, tt.want)
+		}
+	}
+}
+
+func TestHelloFallback(t *testing.T) {
+	tests := []struct {
+		reply string
+		resp  int
+		err   bool
+	}{
+		{reply: "%1\r\n$6\r\nserver\r\n$5\r\nredis\r\n", resp: 3},
+		// redis before 6 doesn't know HELLO, it's RESP2 then
+		{reply: "-ERR unknown command 'HELLO', with args beginning with: '3' \r\n", resp: 2},
+		{reply: "-NOPROTO unsupported protocol version\r\n", resp: 2},
+		// credentials are rejected, AUTH wouldn't do better
+		{reply: "-WRONGPASS invalid username-password pair or user is disabled.\r\n", resp: 3, err: true},
+	}
+	for _, tt := range tests {
+		c := fakeNodeConn(t, tt.reply)
+		var buf bytes.Buffer
+		c.writer, c.resp = &buf, 3
+		err := c.hello()
+		if (err != nil) != tt.err || c.resp != tt.resp {
+			t.Errorf("hello(%q) = %v, RESP%d, want error %v, RESP%d", tt.reply, err, c.resp, tt.err, tt.resp)
+		}
+		if strings.HasPrefix(tt.reply, "-") && !strings.HasPrefix(buf.String(), "HELLO 3 failed: ") {
+			t.Errorf("hello(%q) output = %q, want HELLO 3 failed", tt.reply, buf.String())
+		}
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		start   time.Time
+		timeout time.Duration
+		min     time.Duration
+		max     time.Duration
+	}{
+		{name: "no timeout", start: now, timeout: 0, min: 0, max: 0},
+		{name: "negative timeout", start: now, timeout: -time.Second, min: -time.Second, max: -time.Second},
+		{name: "just started", start: now, timeout: 5 * time.Second, min: 4 * time.Second, max: 5 * time.Second},
+		{name: "half elapsed", start: now.Add(-2 * time.Second), timeout: 4 * time.Second, min: time.Second, max: 2 * time.Second},
+		// an expired timeout stays positive, so it isn't taken as no timeout
+		{name: "expired", start: now.Add(-10 * time.Second), timeout: time.Second, min: time.Nanosecond, max: time.Nanosecond},
+	}
+	for _, tt := range tests {
+		if got := remaining(tt.start, tt.timeout); got < tt.min || got > tt.max {
+			t.Errorf("remaining(%s) = %v, want between %v and %v", tt.name, got, tt.min, tt.max)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reply of one host in fleet mode, output is formatted the same way as a single host
+type hostResult struct {
+	addr   string
+	tv     *TypedVal
+	err    error
+	output []byte
+}
+
+func (r *hostResult) failed() bool {
+	return r.err != nil || r.tv == nil || r.tv.IsError()
+}
+
+// fleet mode: -h with comma separated hosts, or --hosts-file
+func isFleet() bool {
+	return args.HostsFile != "" || strings.Contains(args.Hostname, ",")
+}
+
+// host[:port] list from --hosts-file or -h, blank lines and # comments are skipped
+func fleetHosts() ([]string, error) {
+	var hosts []string
+	if args.HostsFile != "" {
+		file, err := os.Open(args.HostsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				hosts = append(hosts, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, host := range strings.Split(args.Hostname, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hosts given")
+	}
+	return hosts, nil
+}
+
+// run command on all hosts concurrently and print results in the order of hosts,
+// returns exit code, 1 if any host failed
+func fleet(argv []string) int {
+	if args.Repeat != 1 || args.Interval != 0 {
+		fmt.Println("Options -r and -i can't be used with many hosts (-h host1,host2 or --hosts-file)")
+		return 1
+	}
+	hosts, err := fleetHosts()
+	if err != nil {
+		fmt.Printf("Could not read hosts: %s\n", err.Error())
+		return 1
+	}
+	// --askpass is asked only once for all hosts
+	pass := NewConnection(args).password()
+	var deadline time.Time
+	if args.Deadline > 0 {
+		deadline = time.Now().Add(seconds(args.Deadline))
+	}
+
+	results := make([]*hostResult, len(hosts))
+	var wg sync.WaitGroup
+	for i, addr := range hosts {
+		host, port, err := parseHostPort(addr, args.Port)
+		if err != nil {
+			results[i] = &hostResult{addr: addr, err: err}
+			continue
+		}
+		hargs := *args
+		// -s would make every host the same server
+		hargs.Hostname, hargs.Port, hargs.Socket, hargs.HostsFile = host, port, "", ""
+		conn := NewConnection(&hargs)
+		conn.pass, conn.passRead = pass, true
+		conn.deadline = deadline
+		results[i] = &hostResult{addr: joinHostPort(host, port)}
+		wg.Add(1)
+		go func(r *hostResult, conn *Connection) {
+			defer wg.Done()
+			r.tv, r.err = fleetExec(conn, argv, &r.output)
+		}(results[i], conn)
+	}
+	wg.Wait()
+
+	switch {
+	case args.Json || args.QuotedJson || args.Ndjson:
+		printFleetJson(results)
+	case args.Csv:
+		printFleetCsv(os.Stdout, results)
+	default:
+		printFleetText(results)
+	}
+	for _, r := range results {
+		if r.failed() {
+			return 1
+		}
+	}
+	return 0
+}
+
+// exec command on one host, formatted output is written to buffer
+func fleetExec(conn *Connection, argv []string, output *[]byte) (*TypedVal, error) {
+	var buf bytes.Buffer
+	conn.writer = &buf
+	defer func() {
+		*output = buf.Bytes()
+	}()
+	if err := conn.Connect(); err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	tv, err := conn.ExecArgs(argv...)
+	if err != nil {
+		return nil, err
+	}
+	if isCmd(argv, "info") && !tv.IsError() {
+		conn.PrintRawString(tv.String())
+	} else {
+		conn.PrintVal(tv)
+	}
+	return tv, nil
+}
+
+// ==> host:port <== header before output of each host
+func printFleetText(results []*hostResult) {
+	for i, r := range results {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("==> %s <==\n", r.addr)
+		_, _ = os.Stdout.Write(r.output)
+		if r.err != nil && !bytes.Contains(r.output, []byte(r.err.Error())) {
+			fmt.Println(r.err.Error())
+		}
+	}
+}
+
+// one JSON object per line: {"host": "...", "ok": true, "reply": ...}
+func printFleetJson(results []*hostResult) {
+	for _, r := range results {
+		row := map[string]any{"host": r.addr, "ok": !r.failed()}
+		switch {
+		case r.err != nil:
+			row["error"] = r.err.Error()
+		case r.tv.IsError():
+			row["error"] = r.tv.String()
+		default:
+			row["reply"] = formatJson(r.tv, args.QuotedJson)
+		}
+		fmt.Println(string(encodeJson(row)))
+	}
+}
+
+// host,status,reply rows, with header, reply is the line of --csv output of a single host,
+// so it's already quoted and an aggregate spans as many columns as it has elements
+func printFleetCsv(w io.Writer, results []*hostResult) {
+	_, _ = fmt.Fprintln(w, "host,status,reply")
+	for _, r := range results {
+		status, reply := "ok", ""
+		switch {
+		case r.err != nil:
+			status, reply = "error", repr(r.err.Error())
+		case r.tv.IsError():
+			status, reply = "error", repr(r.tv.String())
+		default:
+			reply = formatCsv(r.tv)
+		}
+		_, _ = fmt.Fprintf(w, "%s,%s,%s\n", r.addr, status, reply)
+	}
+}
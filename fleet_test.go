@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFleetHosts(t *testing.T) {
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	content := "# cache\n10.0.0.1\n\n  10.0.0.2:6380  \n[::1]:7000\n"
+	if err := os.WriteFile(hostsFile, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		hostname  string
+		hostsFile string
+		want      []string
+		err       bool
+	}{
+		{hostname: "a,b:6380", want: []string{"a", "b:6380"}},
+		{hostname: " a , ,b ", want: []string{"a", "b"}},
+		{hostname: ",", err: true},
+		{hostsFile: hostsFile, want: []string{"10.0.0.1", "10.0.0.2:6380", "[::1]:7000"}},
+		{hostsFile: filepath.Join(t.TempDir(), "missing"), err: true},
+	}
+	saved := *args
+	defer func() { *args = saved }()
+	for _, tt := range tests {
+		args.Hostname, args.HostsFile = tt.hostname, tt.hostsFile
+		got, err := fleetHosts()
+		if (err != nil) != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("fleetHosts(-h %q, --hosts-file %q) = %q, %v, want %q, error %v", tt.hostname, tt.hostsFile, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestFleetRejectsRepeat(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			_ = conn.Close()
+		}
+	}()
+	saved := *args
+	defer func() { *args = saved }()
+	addr := ln.Addr().String()
+	for _, opts := range []struct {
+		repeat   int
+		interval float64
+	}{{-1, 0}, {0, 0}, {3, 0}, {1, 0.5}} {
+		args.Hostname, args.Repeat, args.Interval = addr+","+addr, opts.repeat, opts.interval
+		if code := fleet([]string{"PING"}); code != 1 {
+			t.Errorf("fleet with -r %d -i %v exit code = %d, want 1", opts.repeat, opts.interval, code)
+		}
+	}
+	// refused before connecting
+	if n := accepted.Load(); n != 0 {
+		t.Errorf("%d connections made, want none", n)
+	}
+
+	// -s is ignored, otherwise every host would be the same socket
+	args.Repeat, args.Interval, args.Socket = 1, 0, filepath.Join(t.TempDir(), "missing.sock")
+	fleet([]string{"PING"})
+	if n := accepted.Load(); n != 2 {
+		t.Errorf("%d connections made with -s, want 2", n)
+	}
+}
+
+func TestPrintFleetCsv(t *testing.T) {
+	results := []*hostResult{
+		{addr: "h:1", tv: &TypedVal{Type: TypeBulkString, Val: "7.2.0"}},
+		{addr: "h:2", tv: &TypedVal{Type: TypeArray, Val: []*TypedVal{
+			{Type: TypeBulkString, Val: "a,b"},
+			{Type: TypeInt, Val: 1},
+			{Type: TypeBulkString, Val: nil},
+		}}},
+		{addr: "h:3", tv: &TypedVal{Type: TypeError, Val: "NOAUTH Authentication required."}},
+		{addr: "h:4", err: errors.New(`Could not connect: "refused"`)},
+	}
+	want := "host,status,reply\n" +
+		"h:1,ok,\"7.2.0\"\n" +
+		"h:2,ok,\"a,b\",1,NULL\n" +
+		"h:3,error,\"NOAUTH Authentication required.\"\n" +
+		"h:4,error,\"Could not connect: \\\"refused\\\"\"\n"
+	var buf bytes.Buffer
+	printFleetCsv(&buf, results)
+	if got := buf.String(); got != want {
+		t.Errorf("printFleetCsv =\n%s\nwant\n%s", got, want)
+	}
+}
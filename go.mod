@@ -2,7 +2,11 @@ module github.com/jeschou/redis-cli-standalone
 
 go 1.21
 
-require github.com/c-bata/go-prompt v0.2.6
+require (
+	github.com/c-bata/go-prompt v0.2.6
+	golang.org/x/sys v0.24.0
+	golang.org/x/term v0.23.0
+)
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
@@ -11,6 +15,4 @@ require (
 	github.com/mattn/go-tty v0.0.3 // indirect
 	github.com/pkg/term v1.2.0-beta.2 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
-	golang.org/x/sys v0.24.0 // indirect
-	golang.org/x/term v0.23.0 // indirect
 )
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// JSON form of reply like redis-cli --json: maps are objects, sets and pushes are arrays, null is null,
+// integers and doubles are numbers, and errors are {"error": {"code": "ERR", "message": "..."}}
+func formatJson(tv *TypedVal, quoted bool) json.RawMessage {
+	return appendJson(nil, tv, quoted)
+}
+
+func appendJson(buf []byte, tv *TypedVal, quoted bool) []byte {
+	if tv == nil || tv.Val == nil {
+		return append(buf, "null"...)
+	}
+	switch tv.Type {
+	case TypeInt:
+		return strconv.AppendInt(buf, int64(tv.Val.(int)), 10)
+	case TypeDouble:
+		f, err := strconv.ParseFloat(tv.String(), 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			// inf and nan have no JSON number form
+			return append(buf, jsonString(tv.String(), quoted)...)
+		}
+		return strconv.AppendFloat(buf, f, 'g', -1, 64)
+	case TypeBigNumber:
+		return append(buf, tv.String()...)
+	case TypeBool:
+		return strconv.AppendBool(buf, tv.Val.(bool))
+	case TypeError, TypeBlobError:
+		code, message, _ := strings.Cut(tv.String(), " ")
+		return append(buf, encodeJson(map[string]any{"error": map[string]string{"code": code, "message": message}})...)
+	case TypeArray, TypeSet, TypePush:
+		buf = append(buf, '[')
+		for i, item := range listOf(tv) {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJson(buf, item, quoted)
+		}
+		return append(buf, ']')
+	case TypeMap:
+		buf = append(buf, '{')
+		items := listOf(tv)
+		for i := 0; i+1 < len(items); i += 2 {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			// keys must be strings, aggregate keys are written as their JSON text
+			key := items[i].String()
+			if _, ok := items[i].Val.([]*TypedVal); ok {
+				key = string(appendJson(nil, items[i], quoted))
+			}
+			buf = append(buf, jsonString(key, quoted)...)
+			buf = append(buf, ':')
+			buf = appendJson(buf, items[i+1], quoted)
+		}
+		return append(buf, '}')
+	default:
+		return append(buf, jsonString(tv.String(), quoted)...)
+	}
+}
+
+// string as JSON, with --quoted-json the value is the quoted string of redis-cli,
+// quotes included, so "你" becomes "\"\\xe4\\xbd\\xa0\""
+func jsonString(str string, quoted bool) json.RawMessage {
+	if quoted {
+		return encodeJson(repr(str))
+	}
+	return encodeJson(str)
+}
+
+// encode value as JSON without HTML escaping
+func encodeJson(v any) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// --json, --quoted-json or --ndjson
+func (c *Connection) jsonOutput() bool {
+	return c.args.Json || c.args.QuotedJson || c.args.Ndjson
+}
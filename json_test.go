@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestFormatJson(t *testing.T) {
+	tests := []struct {
+		resp   string
+		quoted bool
+		want   string
+	}{
+		{resp: "+OK\r\n", want: `"OK"`},
+		{resp: "$-1\r\n", want: "null"},
+		{resp: "*-1\r\n", want: "null"},
+		{resp: "_\r\n", want: "null"},
+		{resp: ":42\r\n", want: "42"},
+		{resp: ",1.50\r\n", want: "1.5"},
+		{resp: ",inf\r\n", want: `"inf"`},
+		{resp: ",nan\r\n", want: `"nan"`},
+		{resp: "(3492890328409238509324850943850943825024385\r\n", want: "3492890328409238509324850943850943825024385"},
+		{resp: "#t\r\n", want: "true"},
+		{resp: "-WRONGTYPE Operation against a key\r\n", want: `{"error":{"code":"WRONGTYPE","message":"Operation against a key"}}`},
+		{resp: "$7\r\na<b>&\"c\r\n", want: `"a<b>&\"c"`},
+		{resp: "$6\r\n\xe4\xbd\xa0\x00\n\x01\r\n", want: `"你\u0000\n\u0001"`},
+		{resp: "$6\r\n\xe4\xbd\xa0\x00\n\x01\r\n", quoted: true, want: `"\"\\xe4\\xbd\\xa0\\x00\\n\\x01\""`},
+		{resp: "*0\r\n", want: "[]"},
+		{resp: "*3\r\n$1\r\na\r\n:1\r\n$-1\r\n", want: `["a",1,null]`},
+		{resp: "~2\r\n$1\r\nx\r\n$1\r\ny\r\n", want: `["x","y"]`},
+		{resp: ">2\r\n$7\r\nmessage\r\n$2\r\nch\r\n", want: `["message","ch"]`},
+		{resp: "%0\r\n", want: "{}"},
+		{resp: "%2\r\n$1\r\nk\r\n%1\r\n$5\r\ninner\r\n~1\r\n$1\r\nx\r\n:1\r\n#f\r\n", want: `{"k":{"inner":["x"]},"1":false}`},
+		{resp: "%1\r\n*2\r\n$1\r\na\r\n:1\r\n$1\r\nv\r\n", want: `{"[\"a\",1]":"v"}`},
+	}
+	for _, tt := range tests {
+		if got := string(formatJson(readResp(t, tt.resp), tt.quoted)); got != tt.want {
+			t.Errorf("formatJson(%q, %v) = %s, want %s", tt.resp, tt.quoted, got, tt.want)
+		}
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
-	"context"
+	"bufio"
+	"errors"
 	"fmt"
 	"github.com/c-bata/go-prompt"
+	"golang.org/x/term"
+	"io"
 	"os"
 	"os/signal"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -22,6 +26,7 @@ type Args struct {
 	Pass               string  `flag:"pass" desc:"Alias of -a for consistency with the new --user option"`
 	Askpass            bool    `flag:"askpass" desc:"Force user to input password with mask from STDIN"`
 	Uri                string  `flag:"u" desc:"Server URI"`
+	HostsFile          string  `flag:"hosts-file" desc:"Run the command on every host[:port] listed in file"`
 	Repeat             int     `flag:"r" default:"1" desc:"Execute specified command N times"`
 	Interval           float64 `flag:"i" default:"0" desc:"Interval between commands when using -r"`
 	Db                 int     `flag:"n" default:"0" desc:"Database number"`
@@ -42,13 +47,28 @@ type Args struct {
 	Key                string  `flag:"key" desc:"Private key file to authenticate with"`
 	TlsCiphers         string  `flag:"tls-ciphers" desc:"Sets the list of preferred ciphers (TLSv1.2 and below)"`
 	TlsCiphersuites    string  `flag:"tls-ciphersuites" desc:"Sets the list of preferred ciphersuites (TLSv1.3)"`
+	TlsDebug           bool    `flag:"tls-debug" desc:"Print TLS session and certificate chain when connecting"`
+	Keepalive          int     `flag:"keepalive" default:"15" desc:"TCP keepalive interval in seconds, 0 to disable"`
+	IdlePing           int     `flag:"idle-ping" default:"0" desc:"Send PING after <sec> idle seconds in interactive mode"`
+	ConnectTimeout     float64 `flag:"connect-timeout" default:"0" desc:"Timeout in seconds for connecting to the server"`
+	Timeout            float64 `flag:"timeout" default:"0" desc:"Timeout in seconds for waiting for a reply"`
+	Deadline           float64 `flag:"deadline" default:"0" desc:"Abort if the whole run takes longer than <sec> seconds"`
+	Sentinel           string  `flag:"sentinel" desc:"Sentinel addresses to discover the master, host:port[,host:port...]"`
+	MasterName         string  `flag:"master-name" desc:"Master name monitored by sentinel"`
+	SentinelReplica    bool    `flag:"sentinel-replica" desc:"Connect to a healthy replica discovered by sentinel"`
 	Raw                bool    `flag:"raw" desc:"Use raw formatting for replies"`
 	NoRaw              bool    `flag:"no-raw" desc:"Force formatted output"`
 	QuotedInput        bool    `flag:"quoted-input" desc:"Force input to be handled as quoted strings"`
 	Csv                bool    `flag:"csv" desc:"Output in CSV format"`
 	Json               bool    `flag:"json" desc:"Output in JSON format"`
 	QuotedJson         bool    `flag:"quoted-json" desc:"Produce ASCII-safe quoted strings, not Unicode"`
-	ShowPushes         string  `flag:"show-pushes" default:"yes" desc:"Whether to print RESP3 PUSH messages"`
+	Ndjson             bool    `flag:"ndjson" desc:"Output one JSON record per reply, with metadata"`
+	NdjsonMeta         string  `flag:"ndjson-meta" default:"time,server,command,elapsed" desc:"Metadata fields of --ndjson records"`
+	ShowPushes         string  `flag:"show-pushes" desc:"Whether to print RESP3 PUSH messages"`
+	MonitorCmd         string  `flag:"monitor-cmd" desc:"In MONITOR, only show these commands, comma separated"`
+	MonitorKey         string  `flag:"monitor-key" desc:"In MONITOR, only show commands whose first argument matches glob"`
+	MonitorClient      string  `flag:"monitor-client" desc:"In MONITOR, only show commands of clients matching glob"`
+	MonitorDb          int     `flag:"monitor-db" default:"-1" desc:"In MONITOR, only show commands on this database"`
 	Stat               bool    `flag:"stat" desc:"Print rolling stats about server"`
 	Latency            bool    `flag:"latency" desc:"Enter a special mode continuously sampling latency"`
 	LatencyHistory     bool    `flag:"latency-history" desc:"Like --latency but tracking latency changes over time"`
@@ -82,13 +102,30 @@ var args = &Args{}
 
 var connection *Connection
 
+// connection is shared by prompt executor and idle ping
+var execLock sync.Mutex
+
+var ttyState *term.State
+
 func main() {
 	restArgs := parseArgs(args)
+	args.DelimiterBulk, args.DelimiterResponses = Unescape(args.DelimiterBulk), Unescape(args.DelimiterResponses)
 	//debugPrintArgs(args)
 	if args.Help {
 		printHelp()
 		return
 	}
+	if args.Sentinel != "" && args.MasterName == "" {
+		fmt.Println("--sentinel requires --master-name")
+		os.Exit(1)
+	}
+	if _, err := parseNdjsonMeta(args.NdjsonMeta); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if args.Cluster != "" {
+		os.Exit(clusterManager(args.Cluster, restArgs))
+	}
 	var err error
 	if args.Scan {
 		err = scan()
@@ -96,59 +133,145 @@ func main() {
 		// redis-cli -h xx -p xx -a xx cmd arg1 arg2 ...
 		// restArgs = [cmd arg1 arg2 ...]
 		// since first arg that not defined, will be use as command and it's args
+		// args are already split by shell, so they are sent as is, unless --quoted-input
+		if args.QuotedInput {
+			for i, a := range restArgs {
+				if restArgs[i], err = UnquoteArg(a); err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+			}
+		}
+		if args.ReadLastArg || args.ReadTagArg != "" {
+			if restArgs, err = readStdinArg(restArgs); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+		if isFleet() {
+			os.Exit(fleet(restArgs))
+		}
 		err = singleCmd(func(connection *Connection) error {
-			return connection.ExecPrint(strings.Join(restArgs, " "))
+			return connection.ExecPrintArgs(restArgs...)
 		})
+	} else if isFleet() {
+		fmt.Println("Running on many hosts (-h host1,host2 or --hosts-file) requires a command")
+		os.Exit(1)
+	} else if !term.IsTerminal(int(os.Stdin.Fd())) {
+		err = batch()
 	} else {
 		interactive()
 	}
-	if err != nil && args.ExitError {
+	if args.ExitError && (err != nil || (connection != nil && connection.failed)) {
 		os.Exit(1)
 	}
 }
 
 // main loop for interactive mode
 func interactive() {
-	ctx, stop := signal.NotifyContext(context.TODO(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
 
 	connection = NewConnection(args)
-	defer connection.Close()
+	connection.reconnect = true
+	defer func() {
+		// a command still waiting for reply holds the lock, its connection is closed on exit anyway
+		if execLock.TryLock() {
+			_ = connection.Close()
+			execLock.Unlock()
+		}
+	}()
+
+	if args.IdlePing > 0 {
+		go idlePing(time.Duration(args.IdlePing) * time.Second)
+	}
+
+	// terminal state before go-prompt enters raw mode
+	ttyState, _ = term.GetState(int(os.Stdin.Fd()))
 
 	go func() {
-		connection.Connect()
-		p := prompt.New(executor, completer, prompt.OptionPrefix(connection.CliPrefix()+"> "), prompt.OptionLivePrefix(func() (string, bool) {
+		// idle ping may use the connection meanwhile
+		execLock.Lock()
+		_ = connection.Connect()
+		prefix := connection.CliPrefix() + "> "
+		execLock.Unlock()
+		p := prompt.New(executor, completer, prompt.OptionPrefix(prefix), prompt.OptionLivePrefix(func() (string, bool) {
+			execLock.Lock()
+			defer execLock.Unlock()
 			return connection.CliPrefix() + "> ", true
 		}))
 		p.Run()
 	}()
 
-	<-ctx.Done()
-	stop()
+	// Ctrl-C only aborts the command waiting for reply, otherwise exit
+	for sig := range sigs {
+		if sig == syscall.SIGINT && connection.Interrupt() {
+			continue
+		}
+		break
+	}
+}
+
+// keep connection alive by PING, and reconnect if it's lost when idle
+func idlePing(interval time.Duration) {
+	for range time.Tick(time.Second) {
+		execLock.Lock()
+		if connection.connected && time.Since(connection.lastActive) >= interval {
+			_, _ = connection.ExecArgs("PING")
+		}
+		execLock.Unlock()
+	}
 }
 
 func executor(input string) {
+	execLock.Lock()
+	defer execLock.Unlock()
+	// go-prompt's TearDown doesn't leave raw mode, since SetRaw modifies the termios it saved as original,
+	// so restore the state from before the prompt, then Ctrl-C raises SIGINT and aborts the command
+	if ttyState != nil {
+		_ = term.Restore(int(os.Stdin.Fd()), ttyState)
+	}
+	runLine(input)
+}
+
+// run a line typed in interactive mode, caller holds execLock
+func runLine(input string) {
 	if !connection.connected {
 		err := connection.Connect()
 		if err != nil {
 			return
 		}
 	}
-	input = strings.TrimSpace(input)
-	if input == "" {
+	argv, err := SplitArgs(input)
+	if err != nil {
+		fmt.Println(err.Error())
 		return
 	}
-	if isCmd(input, "exit") || isCmd(input, "quit") {
+	if len(argv) == 0 {
+		return
+	}
+	if isCmd(argv, "exit") || isCmd(argv, "quit") {
 		os.Exit(0)
 	}
-	if err := connection.ExecPrint(input); err != nil {
+	if isCmd(argv, ":tls") {
+		connection.PrintTlsInfo()
+		return
+	}
+	if isCmd(argv, ":all") {
+		if err := connection.ExecAll(argv[1:]); err != nil {
+			fmt.Println(err.Error())
+		}
+		return
+	}
+	if err := connection.ExecPrintArgs(argv...); err != nil {
 		fmt.Println(err.Error())
 	}
 }
 
-// check if input is specific command or not
-func isCmd(input, cmd string) bool {
-	return strings.EqualFold(strings.Fields(input)[0], cmd)
+// check if argv is specific command or not
+func isCmd(argv []string, cmd string) bool {
+	return len(argv) > 0 && strings.EqualFold(argv[0], cmd)
 }
 
 // todo: add more commands
@@ -201,6 +324,9 @@ func parseArgs(ptr any) (rest []string) {
 					fv.SetValue("")
 				} else if i+1 < len(os.Args) {
 					fv.SetValue(os.Args[i+1])
+					if key == "u" {
+						applyUri(fieldsMap, os.Args[i+1])
+					}
 					i++
 					continue
 				} else {
@@ -245,7 +371,16 @@ Usage: redis-cli [OPTIONS] [cmd [arg [arg ...]]]
                      If this argument is used, '-a' and REDISCLI_AUTH
                      environment variable will be ignored.
   -u <uri>           Server URI.
-  -r <repeat>        Execute specified command N times.
+  --hosts-file <file> Run the command on every host[:port] listed in <file>, one
+                     per line. -h also accepts a comma separated list of hosts.
+                     The command runs on all hosts concurrently, and the output of
+                     each host is printed under its header, or as rows keyed by host
+                     with --json or --csv. Exit code is 1 if any host fails.
+                     -r and -i can't be used with many hosts.
+  -r <repeat>        Execute specified command N times, -1 to repeat forever.
+                     Blocking commands like BLPOP or XREAD BLOCK make simple queue
+                     consumers: timed out waits print (nil) like redis-cli, and a lost
+                     connection is reconnected.
   -i <interval>      When -r is used, waits <interval> seconds per command.
                      It is possible to specify sub-second times like -i 0.1.
                      This interval is also used in --scan and --stat per cycle.
@@ -258,9 +393,14 @@ Usage: redis-cli [OPTIONS] [cmd [arg [arg ...]]]
   -d <delimiter>     Delimiter between response bulks for raw formatting (default: \n).
   -D <delimiter>     Delimiter between responses for raw formatting (default: \n).
   -c                 Enable cluster mode (follow -ASK and -MOVED redirections).
+                     In interactive mode, use :all [masters|replicas|nodes] [--aggregate] <cmd>
+                     to run a command on every master (default), replica or node.
+                     --aggregate runs on masters only.
   -e                 Return exit error code when command execution fails.
+                     When commands are read from STDIN, the first failing one stops them.
   --tls              Establish a secure TLS connection.
-  --sni <host>       Server name indication for TLS.
+  --sni <host>       Server name indication for TLS. With -s, the certificate
+                     is verified against it, or against -h if not given.
   --cacert <file>    CA Certificate file to verify with.
   --cacertdir <dir>  Directory where trusted CA certificates are stored.
                      If neither cacert nor cacertdir are specified, the default
@@ -271,10 +411,36 @@ Usage: redis-cli [OPTIONS] [cmd [arg [arg ...]]]
   --tls-ciphers <list> Sets the list of preferred ciphers (TLSv1.2 and below)
                      in order of preference from highest to lowest separated by colon (":").
                      See the ciphers(1ssl) manpage for more information about the syntax of this string.
+                     Cipher names, keywords like HIGH, ECDHE, AESGCM or SHA256, "+" to combine
+                     them, "!", "-" and "+" prefixes and @STRENGTH are supported. Keywords of
+                     ciphers go doesn't implement (aNULL, DHE, CAMELLIA...) match nothing.
   --tls-ciphersuites <list> Sets the list of preferred ciphersuites (TLSv1.3)
                      in order of preference from highest to lowest separated by colon (":").
                      See the ciphers(1ssl) manpage for more information about the syntax of this string,
                      and specifically for TLSv1.3 ciphersuites.
+  --tls-debug        Print negotiated TLS session, server certificate chain and
+                     verification result when connecting. In interactive mode,
+                     use :tls to print them for the current connection.
+  --keepalive <sec>  TCP keepalive interval in seconds (default: 15), 0 to disable.
+  --idle-ping <sec>  In interactive mode, send PING when connection is idle for
+                     <sec> seconds, to keep it alive through proxies and detect
+                     lost connections early (default: 0, disabled).
+                     Lost connections are reconnected automatically in interactive
+                     mode, restoring protocol, auth, client name and db.
+  --connect-timeout <sec> Timeout for connecting to the server, 0 to wait forever
+                     (default: 0). Sub-second times like 0.5 are supported.
+  --timeout <sec>    Timeout for waiting for each reply, 0 to wait forever (default: 0).
+  --deadline <sec>   Abort with error if the whole run (connecting, executing
+                     and repeating commands) takes longer than <sec> seconds.
+                     In interactive mode, Ctrl-C aborts the command waiting for
+                     reply and returns to the prompt.
+  --sentinel <host:port[,host:port...]> Discover the master through sentinel
+                     (overrides hostname, port and socket). The master is resolved
+                     again when the connection is lost, or when a READONLY error
+                     shows that it has been demoted by a failover.
+  --master-name <name> Master name monitored by sentinel, required by --sentinel.
+  --sentinel-replica Connect to a healthy replica of the master discovered by
+                     sentinel instead of the master.
   --raw              Use raw formatting for replies (default when STDOUT is
                      not a tty).
   --no-raw           Force formatted output even when STDOUT is not a tty.
@@ -282,8 +448,24 @@ Usage: redis-cli [OPTIONS] [cmd [arg [arg ...]]]
   --csv              Output in CSV format.
   --json             Output in JSON format (default RESP3, use -2 if you want to use with RESP2).
   --quoted-json      Same as --json, but produce ASCII-safe quoted strings, not Unicode.
+  --ndjson           Output one compact JSON record per line for every reply, key of
+                     --scan, MONITOR line and pub/sub message, like
+                     {"time":...,"server":...,"command":[...],"elapsed_ms":...,"reply":...}.
+                     Useful with -r, --scan, MONITOR and SUBSCRIBE piped to jq (default RESP3,
+                     strings are quoted by --quoted-json).
+  --ndjson-meta <fields> Metadata of --ndjson records, comma separated from
+                     time,server,command,elapsed (default: all), or none.
   --show-pushes <yn> Whether to print RESP3 PUSH messages.  Enabled by default when
                      STDOUT is a tty but can be overridden with --show-pushes no.
+  --monitor-cmd <cmds> In MONITOR, only show these commands, comma separated (e.g. set,del).
+  --monitor-key <glob> In MONITOR, only show commands whose first argument (the key
+                     of most commands) matches <glob>, like user:*.
+  --monitor-client <glob> In MONITOR, only show commands of clients matching <glob>,
+                     like 10.0.0.5:* or lua.
+  --monitor-db <db>  In MONITOR, only show commands on database <db>.
+                     MONITOR prints commands as the server sends them, or one JSON
+                     object per line with --json, or CSV rows with --csv.
+                     In interactive mode, Ctrl-C stops it and returns to the prompt.
   --stat             Print rolling stats about server: mem, clients, ...
   --latency          Enter a special mode continuously sampling latency.
                      If you use this mode in an interactive session it runs
@@ -386,41 +568,128 @@ func forEachExportedField(ptr any, visitor func(f reflect.StructField, v reflect
 }
 
 func singleCmd(exeFunc func(connection *Connection) error) error {
+	return withConnection(func(connection *Connection) error {
+		return repeatCmd(connection, exeFunc)
+	})
+}
+
+// connect to server and run fn, connection is closed after it
+func withConnection(fn func(connection *Connection) error) error {
 	connection = NewConnection(args)
 	defer connection.Close()
+	if args.Deadline > 0 {
+		connection.deadline = time.Now().Add(seconds(args.Deadline))
+	}
 	if err := connection.Connect(); err != nil {
 		return err
-	} else {
-		// repeat command with interval
-		if args.Repeat == 0 {
-			return exeFunc(connection)
+	}
+	return fn(connection)
+}
+
+// repeat command with interval
+func repeatCmd(connection *Connection, exeFunc func(connection *Connection) error) (err error) {
+	if args.Repeat == 0 {
+		err = exeFunc(connection)
+		if err != nil {
+			fmt.Println(err.Error())
 		}
-		dua := time.Nanosecond * time.Duration(args.Interval*float64(time.Second))
-		for i := 0; i < args.Repeat; i++ {
-			err = exeFunc(connection)
-			if err != nil {
+		return err
+	}
+	// a negative count repeats forever, lost connection is reconnected to keep long running loops,
+	// like queue consumers made of blocking commands, going
+	connection.reconnect = args.Repeat < 0 || args.Repeat > 1
+	dua := time.Nanosecond * time.Duration(args.Interval*float64(time.Second))
+	for i := 0; args.Repeat < 0 || i < args.Repeat; i++ {
+		err = exeFunc(connection)
+		if err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+		if (args.Repeat < 0 || i < args.Repeat-1) && dua > 0 {
+			if !connection.deadline.IsZero() && time.Now().Add(dua).After(connection.deadline) {
+				fmt.Println(ErrDeadline.Error())
+				return ErrDeadline
+			}
+			time.Sleep(dua)
+		}
+	}
+	return nil
+}
+
+// -x appends stdin as the last argument, -X <tag> replaces the argument equal to tag with it,
+// stdin is taken as is, so it can be binary, like a DUMP payload
+func readStdinArg(argv []string) ([]string, error) {
+	if args.ReadLastArg && args.ReadTagArg != "" {
+		return nil, errors.New("Options -x and -X are mutually exclusive.")
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	if args.ReadLastArg {
+		return append(argv, string(data)), nil
+	}
+	for i, arg := range argv {
+		if arg == args.ReadTagArg {
+			argv[i] = string(data)
+			return argv, nil
+		}
+	}
+	return nil, errors.New("Using -X option but stdin tag not match.")
+}
+
+// stdin is not a terminal, run a command per line of it, like: echo -e "SET a 1\nGET a" | redis-cli
+// each command is repeated by -r and -i, and with -e the first failing command stops the batch
+func batch() error {
+	reader := bufio.NewReader(os.Stdin)
+	return withConnection(func(connection *Connection) error {
+		for {
+			line, readErr := reader.ReadString('\n')
+			argv, err := SplitArgs(strings.TrimRight(line, "\r\n"))
+			switch {
+			case err != nil:
+				fmt.Println(err.Error())
+			case len(argv) == 0:
+			case isCmd(argv, "exit") || isCmd(argv, "quit"):
+				return nil
+			default:
+				err = repeatCmd(connection, func(connection *Connection) error {
+					return connection.ExecPrintArgs(argv...)
+				})
+			}
+			if args.ExitError && (err != nil || connection.failed) {
 				return err
 			}
-			if i < args.Repeat-1 && dua > 0 {
-				time.Sleep(dua)
+			if readErr != nil {
+				// EOF
+				return nil
 			}
 		}
-		return nil
-	}
+	})
 }
 
 func scan() error {
 	return singleCmd(func(connection *Connection) error {
 		cursor := "0"
 		for {
-			tv, err := connection.Exec(fmt.Sprintf("SCAN %s MATCH %s COUNT %d", cursor, args.Pattern, args.Count))
+			tv, err := connection.ExecArgs("SCAN", cursor, "MATCH", args.Pattern, "COUNT", strconv.Itoa(args.Count))
 			if err != nil {
 				return err
 			}
-			for _, item := range tv.Val.([]*TypedVal)[1].Val.([]*TypedVal) {
+			if tv.IsError() {
+				// e.g. NOAUTH or NOPERM, print it like any command reply
+				connection.failed = true
+				connection.PrintVal(tv)
+				return nil
+			}
+			items := listOf(tv)
+			if len(items) != 2 {
+				return errors.New("unexpected SCAN reply")
+			}
+			for _, item := range listOf(items[1]) {
 				connection.PrintVal(item)
 			}
-			cursor = tv.Val.([]*TypedVal)[0].Val.(string)
+			cursor = items[0].String()
 			if cursor == "0" {
 				break
 			}
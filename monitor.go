@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// one command seen by MONITOR, parsed from a line like
+//
+//	1339518083.107412 [0 127.0.0.1:60866] "set" "foo" "bar"
+type monitorEntry struct {
+	Time    json.Number `json:"time"`
+	Db      int         `json:"db"`
+	Client  string      `json:"client"` // host:port, unix:/path/to/socket or lua
+	Command string      `json:"command"`
+	Args    []string    `json:"args"`
+}
+
+func parseMonitorLine(line string) (*monitorEntry, error) {
+	ts, rest, ok := strings.Cut(line, " [")
+	if !ok {
+		return nil, fmt.Errorf("unexpected monitor line: %s", line)
+	}
+	origin, quoted, ok := strings.Cut(rest, "] ")
+	if !ok {
+		return nil, fmt.Errorf("unexpected monitor line: %s", line)
+	}
+	dbStr, client, _ := strings.Cut(origin, " ")
+	db, err := strconv.Atoi(dbStr)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected monitor line: %s", line)
+	}
+	// arguments are quoted by server the same way as sdssplitargs expects
+	argv, err := SplitArgs(quoted)
+	if err != nil || len(argv) == 0 {
+		return nil, fmt.Errorf("unexpected monitor line: %s", line)
+	}
+	return &monitorEntry{
+		Time:    json.Number(ts),
+		Db:      db,
+		Client:  client,
+		Command: strings.ToLower(argv[0]),
+		Args:    argv[1:],
+	}, nil
+}
+
+// JSON line of entry, arguments are quoted like replies by --quoted-json
+func (e *monitorEntry) toJson(quoted bool) []byte {
+	argv := make([]json.RawMessage, len(e.Args))
+	for i, arg := range e.Args {
+		argv[i] = jsonString(arg, quoted)
+	}
+	return encodeJson(struct {
+		*monitorEntry
+		Args []json.RawMessage `json:"args"`
+	}{e, argv})
+}
+
+// filters from --monitor-cmd, --monitor-key, --monitor-client and --monitor-db, empty ones match all
+type monitorFilter struct {
+	cmds   map[string]bool
+	key    string
+	client string
+	db     int
+}
+
+func newMonitorFilter(args *Args) *monitorFilter {
+	filter := &monitorFilter{key: args.MonitorKey, client: args.MonitorClient, db: args.MonitorDb}
+	for _, cmd := range strings.Split(args.MonitorCmd, ",") {
+		if cmd = strings.TrimSpace(cmd); cmd != "" {
+			if filter.cmds == nil {
+				filter.cmds = map[string]bool{}
+			}
+			filter.cmds[strings.ToLower(cmd)] = true
+		}
+	}
+	return filter
+}
+
+// key is matched against the first argument, which is the key of most commands
+func (f *monitorFilter) match(e *monitorEntry) bool {
+	if f.cmds != nil && !f.cmds[e.Command] {
+		return false
+	}
+	if f.key != "" && (len(e.Args) == 0 || !globMatch(f.key, e.Args[0])) {
+		return false
+	}
+	if f.client != "" && !globMatch(f.client, e.Client) {
+		return false
+	}
+	return f.db < 0 || f.db == e.Db
+}
+
+// MONITOR until Ctrl-C, lines are printed as is, or as JSON lines with --json and --ndjson, or CSV rows with --csv
+func (c *Connection) Monitor(argv []string) error {
+	if c.cluster != nil {
+		defer c.cluster.active.Store(nil)
+	}
+	node, err := c.streamNode(argv)
+	if err != nil {
+		return err
+	}
+	node.lastCmd, node.lastElapsed = argv, 0
+	tv, err := node.roundTrip(argv)
+	if err != nil {
+		return err
+	}
+	if tv.IsError() {
+		node.PrintVal(tv)
+		return nil
+	}
+	jsonOutput := c.jsonOutput()
+	var csvWriter *csv.Writer
+	switch {
+	case jsonOutput:
+	case c.args.Csv:
+		csvWriter = csv.NewWriter(node.writer)
+		_ = csvWriter.Write([]string{"time", "db", "client", "command", "args"})
+		csvWriter.Flush()
+	default:
+		node.PrintVal(tv)
+	}
+	filter := newMonitorFilter(c.args)
+	for {
+		tv, err := node.receive(0)
+		if errors.Is(err, ErrInterrupted) {
+			// server keeps the connection in monitor mode, so start over with a new one
+			if node.reconnect {
+				return node.Reconnect()
+			}
+			return node.Close()
+		}
+		if err != nil {
+			_ = node.Close()
+			return err
+		}
+		entry, err := parseMonitorLine(tv.String())
+		if err != nil {
+			if !jsonOutput && csvWriter == nil {
+				node.PrintVal(tv)
+			}
+			continue
+		}
+		if !filter.match(entry) {
+			continue
+		}
+		switch {
+		case jsonOutput:
+			node.printJsonLine(entry.toJson(c.args.QuotedJson))
+		case csvWriter != nil:
+			quoted := make([]string, len(entry.Args))
+			for i, arg := range entry.Args {
+				quoted[i] = repr(arg)
+			}
+			_ = csvWriter.Write([]string{entry.Time.String(), strconv.Itoa(entry.Db), entry.Client, entry.Command, strings.Join(quoted, " ")})
+			csvWriter.Flush()
+		default:
+			node.PrintVal(tv)
+		}
+	}
+}
+
+// glob-style pattern matching like redis KEYS: * ? [abc] [^a-z] and \ to escape
+func globMatch(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if globMatch(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) > 1:
+					pattern = pattern[1:]
+					match = match || pattern[0] == str[0]
+				case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+					lo, hi := min(pattern[0], pattern[2]), max(pattern[0], pattern[2])
+					match = match || (str[0] >= lo && str[0] <= hi)
+					pattern = pattern[2:]
+				default:
+					match = match || pattern[0] == str[0]
+				}
+				pattern = pattern[1:]
+			}
+			if match == not {
+				return false
+			}
+			str = str[1:]
+			if len(pattern) > 0 {
+				// skip ']'
+				pattern = pattern[1:]
+			}
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+		}
+		str = str[1:]
+		pattern = pattern[1:]
+	}
+	return len(str) == 0
+}
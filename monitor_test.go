@@ -0,0 +1,121 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"*:name", "user:1:name", true},
+		{"a**b", "axxb", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h[\]]llo`, "h]llo", true},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{"10.0.0.*:*", "10.0.0.7:52310", true},
+		{"abc", "abcd", false},
+		{"abcd", "abc", false},
+		{"[a]", "", false},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.str); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.str, got, tt.want)
+		}
+	}
+}
+
+func TestParseMonitorLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want *monitorEntry
+	}{
+		{
+			line: `1339518083.107412 [0 127.0.0.1:60866] "SET" "foo" "bar"`,
+			want: &monitorEntry{Time: "1339518083.107412", Db: 0, Client: "127.0.0.1:60866", Command: "set", Args: []string{"foo", "bar"}},
+		},
+		{
+			line: `1339518083.107412 [3 unix:/tmp/redis.sock] "get" "a b\x00\n"`,
+			want: &monitorEntry{Time: "1339518083.107412", Db: 3, Client: "unix:/tmp/redis.sock", Command: "get", Args: []string{"a b\x00\n"}},
+		},
+		{
+			line: `1339518083.107412 [0 lua] "ping"`,
+			want: &monitorEntry{Time: "1339518083.107412", Db: 0, Client: "lua", Command: "ping", Args: []string{}},
+		},
+		{line: "OK"},
+		{line: `1339518083.107412 [x 127.0.0.1:1] "get"`},
+		{line: `1339518083.107412 [0 127.0.0.1:1] `},
+		{line: `1339518083.107412 [0 127.0.0.1:1] "unterminated`},
+	}
+	for _, tt := range tests {
+		got, err := parseMonitorLine(tt.line)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("parseMonitorLine(%q) = %+v, want error", tt.line, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMonitorLine(%q) unexpected error: %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMonitorLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestMonitorEntryJson(t *testing.T) {
+	entry := &monitorEntry{Time: "1339518083.107412", Db: 0, Client: "127.0.0.1:60866", Command: "set", Args: []string{"k", "\xe4\xbd\xa0\n"}}
+	tests := []struct {
+		quoted bool
+		want   string
+	}{
+		{quoted: false, want: `{"time":1339518083.107412,"db":0,"client":"127.0.0.1:60866","command":"set","args":["k","你\n"]}`},
+		{quoted: true, want: `{"time":1339518083.107412,"db":0,"client":"127.0.0.1:60866","command":"set","args":["\"k\"","\"\\xe4\\xbd\\xa0\\n\""]}`},
+	}
+	for _, tt := range tests {
+		if got := string(entry.toJson(tt.quoted)); got != tt.want {
+			t.Errorf("toJson(%v) = %s, want %s", tt.quoted, got, tt.want)
+		}
+	}
+}
+
+func TestMonitorFilter(t *testing.T) {
+	entry := &monitorEntry{Db: 2, Client: "10.0.0.7:52310", Command: "set", Args: []string{"user:1", "v"}}
+	tests := []struct {
+		args Args
+		want bool
+	}{
+		{Args{MonitorDb: -1}, true},
+		{Args{MonitorDb: -1, MonitorCmd: "get, SET"}, true},
+		{Args{MonitorDb: -1, MonitorCmd: "get"}, false},
+		{Args{MonitorDb: -1, MonitorKey: "user:*"}, true},
+		{Args{MonitorDb: -1, MonitorKey: "order:*"}, false},
+		{Args{MonitorDb: -1, MonitorClient: "10.0.0.*"}, true},
+		{Args{MonitorDb: -1, MonitorClient: "127.0.0.1:*"}, false},
+		{Args{MonitorDb: 2}, true},
+		{Args{MonitorDb: 0}, false},
+	}
+	for _, tt := range tests {
+		if got := newMonitorFilter(&tt.args).match(entry); got != tt.want {
+			t.Errorf("filter %+v match = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
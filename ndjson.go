@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metadata fields of --ndjson records, selected by --ndjson-meta
+var ndjsonMetaFields = []string{"time", "server", "command", "elapsed"}
+
+// one reply, MONITOR line or pub/sub message per line with --ndjson, like
+//
+//	{"time":"2024-05-01T10:00:00.123+08:00","server":"127.0.0.1:6379","command":["GET","k"],"elapsed_ms":0.215,"reply":"v"}
+type ndjsonRecord struct {
+	Time      string            `json:"time,omitempty"`
+	Server    string            `json:"server,omitempty"`
+	Command   []json.RawMessage `json:"command,omitempty"`
+	ElapsedMs json.Number       `json:"elapsed_ms,omitempty"`
+	Reply     json.RawMessage   `json:"reply"`
+}
+
+// comma separated fields of --ndjson-meta, "none" or empty for bare replies
+func parseNdjsonMeta(str string) (map[string]bool, error) {
+	meta := map[string]bool{}
+	for _, field := range strings.Split(str, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field == "" || field == "none" {
+			continue
+		}
+		known := false
+		for _, f := range ndjsonMetaFields {
+			known = known || f == field
+		}
+		if !known {
+			return nil, fmt.Errorf("Unknown --ndjson-meta field: %s, expected %s or none", field, strings.Join(ndjsonMetaFields, ","))
+		}
+		meta[field] = true
+	}
+	return meta, nil
+}
+
+// wrap JSON of reply with metadata of the command it's received for
+func (c *Connection) ndjsonLine(reply json.RawMessage) []byte {
+	meta := c.ndjsonMeta
+	record := ndjsonRecord{Reply: reply}
+	if meta["time"] {
+		record.Time = time.Now().Format("2006-01-02T15:04:05.000Z07:00")
+	}
+	if meta["server"] {
+		record.Server = c.serverAddr()
+	}
+	if meta["command"] {
+		for _, arg := range c.lastCmd {
+			record.Command = append(record.Command, jsonString(arg, c.args.QuotedJson))
+		}
+	}
+	if meta["elapsed"] && c.lastElapsed > 0 {
+		// messages streamed by MONITOR and subscribe have no elapsed time
+		record.ElapsedMs = json.Number(strconv.FormatFloat(float64(c.lastElapsed.Microseconds())/1000, 'f', 3, 64))
+	}
+	return encodeJson(record)
+}
+
+// address of the server replying, the node which served the last command in cluster mode
+func (c *Connection) serverAddr() string {
+	if c.cluster != nil {
+		return c.cluster.current.serverAddr()
+	}
+	_, addr := c.address()
+	return addr
+}
+
+// print one JSON line, wrapped as a record with --ndjson
+func (c *Connection) printJsonLine(line []byte) {
+	if c.args.Ndjson {
+		line = c.ndjsonLine(line)
+	}
+	_, _ = fmt.Fprintln(c.writer, string(line))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNdjsonMeta(t *testing.T) {
+	tests := []struct {
+		str  string
+		want map[string]bool
+		err  bool
+	}{
+		{str: "time,server,command,elapsed", want: map[string]bool{"time": true, "server": true, "command": true, "elapsed": true}},
+		{str: "command", want: map[string]bool{"command": true}},
+		{str: " Server , ELAPSED ", want: map[string]bool{"server": true, "elapsed": true}},
+		{str: "none", want: map[string]bool{}},
+		{str: "", want: map[string]bool{}},
+		{str: "time,host", err: true},
+		{str: "elapsed_ms", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseNdjsonMeta(tt.str)
+		if tt.err {
+			if err == nil || !strings.HasPrefix(err.Error(), "Unknown --ndjson-meta field: ") {
+				t.Errorf("parseNdjsonMeta(%q) error = %v, want unknown field", tt.str, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseNdjsonMeta(%q) = %v, %v, want %v", tt.str, got, err, tt.want)
+		}
+	}
+}
+
+func TestNdjsonLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		meta    string
+		quoted  bool
+		cmd     []string
+		elapsed time.Duration
+		want    map[string]any
+	}{
+		{
+			name:    "all fields",
+			meta:    "time,server,command,elapsed",
+			cmd:     []string{"GET", "k"},
+			elapsed: 1500 * time.Microsecond,
+			want:    map[string]any{"time": "", "server": "127.0.0.1:6379", "command": []any{"GET", "k"}, "elapsed_ms": 1.5, "reply": "v"},
+		},
+		{
+			// messages of subscribe and monitor are streamed, so there's no elapsed time
+			name: "streamed",
+			meta: "time,server,command,elapsed",
+			cmd:  []string{"SUBSCRIBE", "ch"},
+			want: map[string]any{"time": "", "server": "127.0.0.1:6379", "command": []any{"SUBSCRIBE", "ch"}, "reply": "v"},
+		},
+		{
+			name:    "selected",
+			meta:    "command",
+			cmd:     []string{"GET", "k"},
+			elapsed: time.Millisecond,
+			want:    map[string]any{"command": []any{"GET", "k"}, "reply": "v"},
+		},
+		{
+			name:    "quoted command",
+			meta:    "command",
+			quoted:  true,
+			cmd:     []string{"GET", "\xe4\xbd\xa0"},
+			elapsed: time.Millisecond,
+			want:    map[string]any{"command": []any{`"GET"`, `"\xe4\xbd\xa0"`}, "reply": "v"},
+		},
+		{
+			name:    "none",
+			meta:    "none",
+			cmd:     []string{"GET", "k"},
+			elapsed: time.Millisecond,
+			want:    map[string]any{"reply": "v"},
+		},
+	}
+	for _, tt := range tests {
+		args := &Args{Hostname: "127.0.0.1", Port: 6379, NdjsonMeta: tt.meta, Ndjson: true, QuotedJson: tt.quoted}
+		c := NewConnection(args)
+		c.lastCmd, c.lastElapsed = tt.cmd, tt.elapsed
+		line := c.ndjsonLine(json.RawMessage(`"v"`))
+		var got map[string]any
+		if err := json.Unmarshal(line, &got); err != nil {
+			t.Errorf("ndjsonLine(%s) = %s, invalid JSON: %v", tt.name, line, err)
+			continue
+		}
+		if ts, ok := got["time"].(string); ok {
+			if _, err := time.Parse("2006-01-02T15:04:05.000Z07:00", ts); err != nil {
+				t.Errorf("ndjsonLine(%s) time = %q, want RFC 3339 with milliseconds", tt.name, ts)
+			}
+			got["time"] = ""
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ndjsonLine(%s) = %s, want %v", tt.name, line, tt.want)
+		}
+	}
+}
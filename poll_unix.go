@@ -0,0 +1,54 @@
+//go:build !windows
+
+package main
+
+import (
+	"crypto/tls"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// file descriptor of connection, TLS is excluded since decrypted data may be buffered inside it
+func connFd(conn net.Conn) (int, bool) {
+	if _, ok := conn.(*tls.Conn); ok {
+		return 0, false
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	fd := -1
+	_ = raw.Control(func(f uintptr) {
+		fd = int(f)
+	})
+	return fd, fd >= 0
+}
+
+// connection and stdin can be waited together
+func pollable(conn net.Conn) bool {
+	_, ok := connFd(conn)
+	return ok
+}
+
+// wait until connection or stdin is readable, no longer than timeout
+func waitReadable(conn net.Conn, timeout time.Duration) (connReady, stdinReady bool) {
+	fd, ok := connFd(conn)
+	if !ok {
+		return true, false
+	}
+	fds := []unix.PollFd{
+		{Fd: int32(fd), Events: unix.POLLIN},
+		{Fd: int32(os.Stdin.Fd()), Events: unix.POLLIN},
+	}
+	if n, err := unix.Poll(fds, int(timeout.Milliseconds())); err != nil || n == 0 {
+		return false, false
+	}
+	return fds[0].Revents != 0, fds[1].Revents != 0
+}
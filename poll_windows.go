@@ -0,0 +1,15 @@
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// stdin can't be polled on windows, messages are read until Ctrl-C
+func pollable(conn net.Conn) bool {
+	return false
+}
+
+func waitReadable(conn net.Conn, timeout time.Duration) (connReady, stdinReady bool) {
+	return true, false
+}
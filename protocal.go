@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 )
 
 type RType byte
@@ -15,10 +16,67 @@ const TypeInt RType = ':'
 const TypeBulkString RType = '$'
 const TypeArray RType = '*'
 
+// RESP3 types
+const TypeMap RType = '%'
+const TypeSet RType = '~'
+const TypeDouble RType = ','
+const TypeBool RType = '#'
+const TypeNull RType = '_'
+const TypeBigNumber RType = '('
+const TypeBlobError RType = '!'
+const TypeVerbatim RType = '='
+const TypeAttribute RType = '|'
+const TypePush RType = '>'
+
 // redis data type and value
 type TypedVal struct {
 	Type RType
-	Val  any // real type may be string, int, []*TypedVal, nil
+	// real type may be string, int, bool, []*TypedVal, nil
+	// map is stored as []*TypedVal of key, value, key, value ...
+	// double and big number are stored as their string representation
+	Val any
+	// attributes sent by server before this value, if any
+	Attr *TypedVal
+}
+
+func (tv *TypedVal) IsError() bool {
+	return tv.Type == TypeError || tv.Type == TypeBlobError
+}
+
+// string form of scalar value, nil is empty string
+func (tv *TypedVal) String() string {
+	if tv == nil || tv.Val == nil {
+		return ""
+	}
+	return fmt.Sprint(tv.Val)
+}
+
+// fields of RESP3 map, or RESP2 array of key value pairs
+func (tv *TypedVal) Pairs() map[string]*TypedVal {
+	res := map[string]*TypedVal{}
+	items, _ := tv.Val.([]*TypedVal)
+	for i := 0; i+1 < len(items); i += 2 {
+		res[items[i].String()] = items[i+1]
+	}
+	return res
+}
+
+// encode command arguments as a RESP multi-bulk array
+//
+//	*<argc>\r\n$<len>\r\n<arg>\r\n...
+func EncodeCommand(argv []string) []byte {
+	buf := make([]byte, 0, 16*len(argv)+16)
+	buf = append(buf, byte(TypeArray))
+	buf = strconv.AppendInt(buf, int64(len(argv)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range argv {
+		buf = append(buf, byte(TypeBulkString))
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
 }
 
 // read typed value from stream, base on redis protocol
@@ -31,11 +89,7 @@ func ReadValue(bufReader *bufio.Reader) (res *TypedVal, err error) {
 	res.Type = RType(typ)
 	var result []byte
 	switch res.Type {
-	case TypeSimpleString: // simple string
-		result, _, err = bufReader.ReadLine()
-		res.Val = string(result)
-		return
-	case TypeError: // err
+	case TypeSimpleString, TypeError, TypeDouble, TypeBigNumber: // simple string, err, double, big number
 		result, _, err = bufReader.ReadLine()
 		res.Val = string(result)
 		return
@@ -43,7 +97,15 @@ func ReadValue(bufReader *bufio.Reader) (res *TypedVal, err error) {
 		result, _, err = bufReader.ReadLine()
 		res.Val, _ = strconv.Atoi(string(result))
 		return
-	case TypeBulkString: // bulk string
+	case TypeBool: // boolean
+		result, _, err = bufReader.ReadLine()
+		res.Val = string(result) == "t"
+		return
+	case TypeNull: // null
+		_, _, err = bufReader.ReadLine()
+		res.Val = nil
+		return
+	case TypeBulkString, TypeBlobError, TypeVerbatim: // bulk string, blob error, verbatim string
 		result, _, err = bufReader.ReadLine()
 		length, _ := strconv.Atoi(string(result))
 		if length == -1 {
@@ -60,11 +122,23 @@ func ReadValue(bufReader *bufio.Reader) (res *TypedVal, err error) {
 			res.Val = string(result)
 			_, _, err = bufReader.ReadLine()
 		}
+		if res.Type == TypeVerbatim && len(result) >= 4 {
+			// skip format prefix, like "txt:"
+			res.Val = string(result[4:])
+		}
 		return
-	case TypeArray: // array
+	case TypeArray, TypeSet, TypePush, TypeMap, TypeAttribute: // aggregate types
 		var count int
 		result, _, err = bufReader.ReadLine()
 		count, _ = strconv.Atoi(string(result))
+		if count < 0 {
+			// null array
+			res.Val = nil
+			return
+		}
+		if res.Type == TypeMap || res.Type == TypeAttribute {
+			count *= 2
+		}
 		res0 := make([]*TypedVal, count)
 		for i := 0; i < count; i++ {
 			v, err := ReadValue(bufReader)
@@ -74,6 +148,16 @@ func ReadValue(bufReader *bufio.Reader) (res *TypedVal, err error) {
 			res0[i] = v
 		}
 		res.Val = res0
+		if res.Type == TypeAttribute {
+			// attributes describe the reply that follows
+			var v *TypedVal
+			v, err = ReadValue(bufReader)
+			if err != nil {
+				return nil, err
+			}
+			v.Attr = res
+			return v, nil
+		}
 		return
 	default:
 		err = fmt.Errorf("unknown response type: %c", res.Type)
@@ -81,44 +165,187 @@ func ReadValue(bufReader *bufio.Reader) (res *TypedVal, err error) {
 	return
 }
 
+// delimiters of raw output, elements of aggregates are separated by bulk (-d),
+// and each response ends with response (-D)
+type rawFormat struct {
+	bulk, response string
+}
+
 // convert typed value to string and print to writer
-// compatible with redis-cli
-func PrintVal(writer io.Writer, res *TypedVal, raw bool) {
+// compatible with redis-cli, formatted if raw is nil
+func PrintVal(writer io.Writer, res *TypedVal, raw *rawFormat) {
+	if raw != nil {
+		_, _ = fmt.Fprint(writer, formatRaw(res, raw.bulk), raw.response)
+		return
+	}
+	_, _ = fmt.Fprint(writer, formatTTY(res, ""))
+}
+
+// formatted form of value like redis-cli, elements of nested aggregates are indented by prefix,
+// which grows by the width of index at each level, so " 1)" and "10)" line up
+func formatTTY(res *TypedVal, prefix string) string {
 	if res.Val == nil {
-		if raw {
-			_, _ = fmt.Fprintf(writer, "\n")
-		} else {
-			_, _ = fmt.Fprintf(writer, "(nil)\n")
+		return "(nil)\n"
+	}
+	switch res.Type {
+	case TypeSimpleString, TypeVerbatim:
+		return fmt.Sprintf("%s\n", res.Val)
+	case TypeBulkString:
+		// quoted like sdscatrepr, so bytes are shown the same way as redis-cli
+		return repr(res.String()) + "\n"
+	case TypeError, TypeBlobError:
+		return fmt.Sprintf("(error) %s\n", res.Val)
+	case TypeInt:
+		return fmt.Sprintf("(integer) %d\n", res.Val)
+	case TypeDouble:
+		return fmt.Sprintf("(double) %s\n", res.Val)
+	case TypeBigNumber:
+		return fmt.Sprintf("(big number) %s\n", res.Val)
+	case TypeBool:
+		return formatBool(res) + "\n"
+	case TypeArray, TypeSet, TypePush, TypeMap:
+		items := res.Val.([]*TypedVal)
+		if len(items) == 0 {
+			return emptyAggregate(res.Type) + "\n"
 		}
-	} else {
-		switch res.Type {
-		case TypeSimpleString:
-			_, _ = fmt.Fprintf(writer, "%s\n", res.Val)
-		case TypeBulkString:
-			if raw {
-				_, _ = fmt.Fprintf(writer, "%s\n", res.Val)
-			} else {
-				_, _ = fmt.Fprintf(writer, "%q\n", res.Val)
+		count := len(items)
+		if res.Type == TypeMap {
+			count /= 2
+		}
+		width := len(strconv.Itoa(count))
+		nested := prefix + strings.Repeat(" ", width+2)
+		var sb strings.Builder
+		for i := 0; i < len(items); i++ {
+			// the first element follows the index printed by parent
+			indent := prefix
+			if i == 0 {
+				indent = ""
 			}
-		case TypeError:
-			if raw {
-				_, _ = fmt.Fprintf(writer, "%s\n", res.Val)
-			} else {
-				_, _ = fmt.Fprintf(writer, "(error) %s\n", res.Val)
+			index := i + 1
+			if res.Type == TypeMap {
+				index = i/2 + 1
 			}
-		case TypeInt:
-			if raw {
-				_, _ = fmt.Fprintf(writer, "%d\n", res.Val)
-			} else {
-				_, _ = fmt.Fprintf(writer, "(integer) %d\n", res.Val)
+			sb.WriteString(fmt.Sprintf("%s%*d%s ", indent, width, index, aggregatePrefix(res.Type)))
+			str := formatTTY(items[i], nested)
+			if res.Type == TypeMap && i+1 < len(items) {
+				// key and value on the same line
+				i++
+				str = strings.TrimSuffix(str, "\n") + " => " + formatTTY(items[i], nested)
 			}
-		case TypeArray:
-			for i, v := range res.Val.([]*TypedVal) {
-				if !raw {
-					_, _ = fmt.Fprintf(writer, "%d) ", i+1)
-				}
-				PrintVal(writer, v, raw)
+			sb.WriteString(str)
+		}
+		return sb.String()
+	}
+	return ""
+}
+
+// message of empty aggregates, like redis-cli: "(empty array)", "(empty hash)"
+func emptyAggregate(typ RType) string {
+	switch typ {
+	case TypeSet:
+		return "(empty set)"
+	case TypeMap:
+		return "(empty hash)"
+	case TypePush:
+		return "(empty push)"
+	default:
+		return "(empty array)"
+	}
+}
+
+// raw form of value, elements of aggregates are joined by delim,
+// a map is flattened to key, value, key, value ...
+func formatRaw(res *TypedVal, delim string) string {
+	if res.Val == nil {
+		return ""
+	}
+	switch res.Type {
+	case TypeBool:
+		// redis-cli prints booleans the same way in raw mode
+		return formatBool(res)
+	case TypeArray, TypeSet, TypePush, TypeMap:
+		items := res.Val.([]*TypedVal)
+		parts := make([]string, len(items))
+		for i, item := range items {
+			parts[i] = formatRaw(item, delim)
+		}
+		return strings.Join(parts, delim)
+	default:
+		return fmt.Sprint(res.Val)
+	}
+}
+
+// CSV form of value like redis-cli --csv, aggregates are flattened into one line,
+// strings are quoted, nil is NULL and errors are prefixed with ERROR,
+func formatCsv(res *TypedVal) string {
+	if res.Val == nil {
+		return "NULL"
+	}
+	switch res.Type {
+	case TypeError, TypeBlobError:
+		return "ERROR," + repr(res.String())
+	case TypeSimpleString, TypeBulkString, TypeVerbatim:
+		return repr(res.String())
+	case TypeBool:
+		return strconv.FormatBool(res.Val.(bool))
+	case TypeArray, TypeSet, TypePush, TypeMap:
+		items := res.Val.([]*TypedVal)
+		parts := make([]string, len(items))
+		for i, item := range items {
+			parts[i] = formatCsv(item)
+		}
+		return strings.Join(parts, ",")
+	default:
+		return fmt.Sprint(res.Val)
+	}
+}
+
+// quote string like redis sdscatrepr: "a\"b\n\x00"
+func repr(str string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; c {
+		case '\\', '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString("\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case '\t':
+			sb.WriteString("\\t")
+		case '\a':
+			sb.WriteString("\\a")
+		case '\b':
+			sb.WriteString("\\b")
+		default:
+			if c >= 0x20 && c < 0x7f {
+				sb.WriteByte(c)
+			} else {
+				_, _ = fmt.Fprintf(&sb, "\\x%02x", c)
 			}
 		}
 	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func formatBool(res *TypedVal) string {
+	if res.Val.(bool) {
+		return "(true)"
+	}
+	return "(false)"
+}
+
+// index suffix of aggregate elements, like redis-cli: "1) ", "1~ ", "1# "
+func aggregatePrefix(typ RType) string {
+	switch typ {
+	case TypeSet:
+		return "~"
+	case TypeMap:
+		return "#"
+	default:
+		return ")"
+	}
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// decode a reply in RESP, like it's received from server
+func readResp(t *testing.T, resp string) *TypedVal {
+	t.Helper()
+	tv, err := ReadValue(bufio.NewReader(strings.NewReader(resp)))
+	if err != nil {
+		t.Fatalf("ReadValue(%q): %v", resp, err)
+	}
+	return tv
+}
+
+func TestFormatRaw(t *testing.T) {
+	tests := []struct {
+		resp  string
+		delim string
+		want  string
+	}{
+		{resp: "+OK\r\n", delim: "\n", want: "OK"},
+		{resp: "-ERR wrong\r\n", delim: "\n", want: "ERR wrong"},
+		{resp: ":42\r\n", delim: "\n", want: "42"},
+		{resp: "$-1\r\n", delim: "\n", want: ""},
+		{resp: "_\r\n", delim: "\n", want: ""},
+		{resp: "$0\r\n\r\n", delim: "\n", want: ""},
+		{resp: "$5\r\na\r\nb\x00\r\n", delim: "\n", want: "a\r\nb\x00"},
+		{resp: "#t\r\n", delim: "\n", want: "(true)"},
+		{resp: ",1.5\r\n", delim: "\n", want: "1.5"},
+		{resp: "=8\r\ntxt:text\r\n", delim: "\n", want: "text"},
+		{resp: "*0\r\n", delim: "\n", want: ""},
+		{resp: "*3\r\n$1\r\na\r\n:2\r\n$-1\r\n", delim: "\n", want: "a\n2\n"},
+		{resp: "*2\r\n$1\r\na\r\n$1\r\nb\r\n", delim: ",", want: "a,b"},
+		{resp: "*2\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", delim: "|", want: "a|b|c"},
+		{resp: "%2\r\n$1\r\nk\r\n$1\r\nv\r\n$2\r\nk2\r\n:1\r\n", delim: " ", want: "k v k2 1"},
+		{resp: "~1\r\n$1\r\nx\r\n", delim: "\n", want: "x"},
+	}
+	for _, tt := range tests {
+		if got := formatRaw(readResp(t, tt.resp), tt.delim); got != tt.want {
+			t.Errorf("formatRaw(%q, %q) = %q, want %q", tt.resp, tt.delim, got, tt.want)
+		}
+	}
+}
+
+func TestPrintValRaw(t *testing.T) {
+	tests := []struct {
+		resp string
+		raw  rawFormat
+		want string
+	}{
+		{resp: "$3\r\nabc\r\n", raw: rawFormat{bulk: "\n", response: "\n"}, want: "abc\n"},
+		{resp: "$3\r\nabc\r\n", raw: rawFormat{bulk: "\n", response: ""}, want: "abc"},
+		{resp: "*2\r\n$1\r\na\r\n$1\r\nb\r\n", raw: rawFormat{bulk: "\t", response: "\r\n"}, want: "a\tb\r\n"},
+		{resp: "*2\r\n$1\r\na\r\n$1\r\nb\r\n", raw: rawFormat{bulk: "", response: ""}, want: "ab"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		PrintVal(&buf, readResp(t, tt.resp), &tt.raw)
+		if got := buf.String(); got != tt.want {
+			t.Errorf("PrintVal(%q, %+v) = %q, want %q", tt.resp, tt.raw, got, tt.want)
+		}
+	}
+}
+
+// output of redis-cli --raw -D "" DUMP key can be fed to redis-cli -X <tag> RESTORE key 0 <tag>,
+// so the payload must pass both ways unchanged
+func TestRawDumpRoundTrip(t *testing.T) {
+	payload := "\x00\x03abc\n\r\x00\xff\x0b\x00\"\\x"
+	var out bytes.Buffer
+	dump := &TypedVal{Type: TypeBulkString, Val: payload}
+	PrintVal(&out, dump, &rawFormat{bulk: Unescape(`\n`), response: Unescape("")})
+	if out.String() != payload {
+		t.Fatalf("raw DUMP output = %q, want %q", out.String(), payload)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, saved := os.Stdin, *args
+	defer func() {
+		os.Stdin, *args = stdin, saved
+	}()
+	os.Stdin = r
+	args.ReadLastArg, args.ReadTagArg = false, "<payload>"
+	go func() {
+		_, _ = w.Write(out.Bytes())
+		_ = w.Close()
+	}()
+	argv, err := readStdinArg([]string{"RESTORE", "key", "0", "<payload>", "REPLACE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if argv[3] != payload {
+		t.Errorf("-X argument = %q, want %q", argv[3], payload)
+	}
+}
+
+func TestFormatCsv(t *testing.T) {
+	tests := []struct {
+		resp string
+		want string
+	}{
+		{resp: "+OK\r\n", want: `"OK"`},
+		{resp: "$-1\r\n", want: "NULL"},
+		{resp: "_\r\n", want: "NULL"},
+		{resp: ":-7\r\n", want: "-7"},
+		{resp: ",3.14\r\n", want: "3.14"},
+		{resp: "#f\r\n", want: "false"},
+		{resp: "-ERR unknown command\r\n", want: `ERROR,"ERR unknown command"`},
+		{resp: "$6\r\na\"b\n\x00\xff\r\n", want: `"a\"b\n\x00\xff"`},
+		{resp: "*0\r\n", want: ""},
+		{resp: "*3\r\n$1\r\na\r\n:1\r\n$-1\r\n", want: `"a",1,NULL`},
+		{resp: "*2\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", want: `"a","b","c"`},
+		{resp: "%1\r\n$1\r\nk\r\n#t\r\n", want: `"k",true`},
+	}
+	for _, tt := range tests {
+		if got := formatCsv(readResp(t, tt.resp)); got != tt.want {
+			t.Errorf("formatCsv(%q) = %s, want %s", tt.resp, got, tt.want)
+		}
+	}
+}
+
+func TestFormatTTY(t *testing.T) {
+	tests := []struct {
+		resp string
+		want string
+	}{
+		{resp: "+OK\r\n", want: "OK\n"},
+		{resp: "$-1\r\n", want: "(nil)\n"},
+		{resp: "$3\r\na\"\n\r\n", want: "\"a\\\"\\n\"\n"},
+		{resp: "$3\r\n\xe4\xbd\xa0\r\n", want: `"\xe4\xbd\xa0"` + "\n"},
+		{resp: "$6\r\n\v\f\x00\x7f\a\b\r\n", want: `"\x0b\x0c\x00\x7f\a\b"` + "\n"},
+		{resp: "$5\r\na\xe2\x80\x8bb\r\n", want: `"a\xe2\x80\x8bb"` + "\n"},
+		{resp: "$4\r\n\\\t\r\n\r\n", want: `"\\\t\r\n"` + "\n"},
+		{resp: "-ERR wrong\r\n", want: "(error) ERR wrong\n"},
+		{resp: ":5\r\n", want: "(integer) 5\n"},
+		{resp: ",1.5\r\n", want: "(double) 1.5\n"},
+		{resp: "(12345678901234567890\r\n", want: "(big number) 12345678901234567890\n"},
+		{resp: "#t\r\n", want: "(true)\n"},
+		{resp: "=8\r\ntxt:text\r\n", want: "text\n"},
+		{resp: "*0\r\n", want: "(empty array)\n"},
+		{resp: "~0\r\n", want: "(empty set)\n"},
+		{resp: "%0\r\n", want: "(empty hash)\n"},
+		{resp: "*2\r\n$1\r\na\r\n$-1\r\n", want: "1) \"a\"\n2) (nil)\n"},
+		{resp: "~2\r\n$1\r\nx\r\n$1\r\ny\r\n", want: "1~ \"x\"\n2~ \"y\"\n"},
+		{resp: "%2\r\n$1\r\nk\r\n:1\r\n$2\r\nk2\r\n*0\r\n", want: "1# \"k\" => (integer) 1\n2# \"k2\" => (empty array)\n"},
+		{
+			resp: "*2\r\n*2\r\n$1\r\na\r\n*1\r\n:1\r\n$1\r\nb\r\n",
+			want: "1) 1) \"a\"\n   2) 1) (integer) 1\n2) \"b\"\n",
+		},
+		{
+			// indexes are right aligned, nested elements line up after the widest one
+			resp: "*10\r\n:1\r\n:2\r\n:3\r\n:4\r\n:5\r\n:6\r\n:7\r\n:8\r\n:9\r\n*2\r\n$1\r\nx\r\n$1\r\ny\r\n",
+			want: " 1) (integer) 1\n 2) (integer) 2\n 3) (integer) 3\n 4) (integer) 4\n 5) (integer) 5\n" +
+				" 6) (integer) 6\n 7) (integer) 7\n 8) (integer) 8\n 9) (integer) 9\n10) 1) \"x\"\n    2) \"y\"\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := formatTTY(readResp(t, tt.resp), ""); got != tt.want {
+			t.Errorf("formatTTY(%q) =\n%s\nwant\n%s", tt.resp, got, tt.want)
+		}
+	}
+}
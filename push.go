@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// handle out-of-band RESP3 push, like pub/sub messages or client side caching invalidations,
+// which may come before any reply, so they're never taken as reply of a command
+func (c *Connection) handlePush(tv *TypedVal) {
+	c.confirmed(tv)
+	if !c.showPushes() {
+		return
+	}
+	if keys, ok := invalidatedKeys(tv); ok && !c.raw() && !c.jsonOutput() {
+		_, _ = fmt.Fprintf(c.writer, "-> invalidate: %s\n", strings.Join(keys, ", "))
+		return
+	}
+	c.printMessage(tv)
+}
+
+// --show-pushes yes|no, by default pushes are shown if stdout is a tty
+func (c *Connection) showPushes() bool {
+	switch strings.ToLower(c.args.ShowPushes) {
+	case "yes":
+		return true
+	case "no":
+		return false
+	default:
+		return c.istty
+	}
+}
+
+// keys of invalidate push: >2 $10 invalidate *2 $1 a $1 b, quoted like redis-cli: 'a', 'b'
+// flushing all keys sends null instead of keys, which is printed as a normal push
+func invalidatedKeys(tv *TypedVal) ([]string, bool) {
+	items := listOf(tv)
+	if tv.Type != TypePush || len(items) != 2 || items[0].String() != "invalidate" {
+		return nil, false
+	}
+	if _, ok := items[1].Val.([]*TypedVal); !ok {
+		return nil, false
+	}
+	var keys []string
+	for _, key := range listOf(items[1]) {
+		keys = append(keys, "'"+key.String()+"'")
+	}
+	return keys, true
+}
+
+// CLIENT TRACKING ON without REDIRECT, invalidations are pushed to this connection only with RESP3
+func isTrackingOn(argv []string) bool {
+	if !isCmd(argv, "client") || len(argv) < 3 || !strings.EqualFold(argv[1], "tracking") || !strings.EqualFold(argv[2], "on") {
+		return false
+	}
+	for _, arg := range argv[3:] {
+		if strings.EqualFold(arg, "redirect") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrRoleMismatch = errors.New("role mismatch")
+
+// resolve address of the master (or a replica with --sentinel-replica) by asking sentinels one by one
+func (c *Connection) resolveSentinel() error {
+	var lastErr error
+	for _, addr := range strings.Split(c.args.Sentinel, ",") {
+		host, port, err := parseHostPort(strings.TrimSpace(addr), 26379)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		host, port, err = querySentinel(c.args, host, port, c.deadline)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if c.resolved && (host != c.host || port != c.port) {
+			_, _ = fmt.Fprintf(c.writer, "Sentinel switched %s to %s\n", c.args.MasterName, joinHostPort(host, port))
+		}
+		c.host, c.port, c.resolved = host, port, true
+		return nil
+	}
+	return fmt.Errorf("Could not resolve '%s' from sentinel %s: %v", c.args.MasterName, c.args.Sentinel, lastErr)
+}
+
+// ask one sentinel for current address of master, or a healthy replica
+func querySentinel(args *Args, host string, port int, deadline time.Time) (string, int, error) {
+	sentinel := NewConnection(sentinelArgs(args))
+	sentinel.host, sentinel.port = host, port
+	sentinel.deadline = deadline
+	if err := sentinel.Connect(); err != nil {
+		return "", 0, err
+	}
+	defer sentinel.Close()
+
+	if args.SentinelReplica {
+		tv, err := sentinel.ExecArgs("SENTINEL", "REPLICAS", args.MasterName)
+		if err != nil {
+			return "", 0, err
+		}
+		if tv.IsError() {
+			return "", 0, fmt.Errorf("%s", tv.Val)
+		}
+		for _, replica := range tv.Val.([]*TypedVal) {
+			info := replica.Pairs()
+			if !healthyReplica(info) {
+				continue
+			}
+			port, _ := strconv.Atoi(info["port"].String())
+			return info["ip"].String(), port, nil
+		}
+		return "", 0, errors.New("no healthy replica")
+	}
+
+	tv, err := sentinel.ExecArgs("SENTINEL", "GET-MASTER-ADDR-BY-NAME", args.MasterName)
+	if err != nil {
+		return "", 0, err
+	}
+	if tv.IsError() {
+		return "", 0, fmt.Errorf("%s", tv.Val)
+	}
+	addr, ok := tv.Val.([]*TypedVal)
+	if !ok || len(addr) != 2 {
+		return "", 0, errors.New("unknown master name")
+	}
+	masterPort, _ := strconv.Atoi(addr[1].Val.(string))
+	return addr[0].Val.(string), masterPort, nil
+}
+
+// sentinel doesn't share auth, db, protocol and output options with data nodes, so it's a plain RESP2 connection
+func sentinelArgs(args *Args) *Args {
+	sargs := *args
+	sargs.Sentinel, sargs.Socket = "", ""
+	sargs.User, sargs.Pass, sargs.Password, sargs.Askpass = "", "", "", false
+	sargs.Db, sargs.Resp3, sargs.TlsDebug = 0, false, false
+	sargs.Json, sargs.QuotedJson, sargs.Ndjson, sargs.ClusterMode = false, false, false, false
+	return &sargs
+}
+
+// replica is usable if it's not down and linked to master
+func healthyReplica(info map[string]*TypedVal) bool {
+	for _, flag := range strings.Split(info["flags"].String(), ",") {
+		switch flag {
+		case "s_down", "o_down", "disconnected":
+			return false
+		}
+	}
+	status := info["master-link-status"].String()
+	return status == "" || status == "ok"
+}
+
+// sentinel resolved address may be stale during failover, so check what the server thinks it is
+func (c *Connection) verifyRole() error {
+	tv, err := c.roundTrip([]string{"ROLE"})
+	if err != nil {
+		return err
+	}
+	if tv.IsError() {
+		// ROLE may be forbidden by ACL, trust sentinel then
+		return nil
+	}
+	want := "master"
+	if c.args.SentinelReplica {
+		want = "slave"
+	}
+	role := ""
+	if items, ok := tv.Val.([]*TypedVal); ok && len(items) > 0 {
+		role, _ = items[0].Val.(string)
+	}
+	if role != want {
+		_ = c.Close()
+		_, addr := c.address()
+		_, _ = fmt.Fprintf(c.writer, "%s is %s, not %s, waiting for sentinel\n", addr, role, want)
+		return fmt.Errorf("%w: %s is %s", ErrRoleMismatch, addr, role)
+	}
+	return nil
+}
+
+// parse "host:port", "[::1]:port" or "host" with default port
+func parseHostPort(addr string, defaultPort int) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		// no port specified
+		return strings.Trim(addr, "[]"), defaultPort, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in address %s", addr)
+	}
+	return host, port, nil
+}
+
+func joinHostPort(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
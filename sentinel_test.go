@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestSentinelArgs(t *testing.T) {
+	args := &Args{
+		Hostname: "10.0.0.1", Sentinel: "10.0.0.2:26379", MasterName: "mymaster", Socket: "/tmp/redis.sock",
+		User: "u", Pass: "p", Password: "p", Askpass: true, Db: 3, Resp3: true, TlsDebug: true,
+		Json: true, QuotedJson: true, Ndjson: true, ClusterMode: true, Tls: true,
+	}
+	saved := *args
+	sargs := sentinelArgs(args)
+	if sargs == args || *args != saved {
+		t.Errorf("sentinelArgs() modified args of data node")
+	}
+	want := Args{Hostname: "10.0.0.1", MasterName: "mymaster", Tls: true}
+	if *sargs != want {
+		t.Errorf("sentinelArgs() = %+v, want %+v", *sargs, want)
+	}
+}
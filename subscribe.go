@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// subscribe commands and their reverse
+var unsubscribeCmds = map[string]string{
+	"subscribe":  "unsubscribe",
+	"psubscribe": "punsubscribe",
+	"ssubscribe": "sunsubscribe",
+}
+
+// one pub/sub message or (un)subscribe confirmation as JSON
+type pubsubEvent struct {
+	Type    string `json:"type"`
+	Pattern any    `json:"pattern,omitempty"`
+	Channel any    `json:"channel,omitempty"`
+	Payload any    `json:"payload,omitempty"`
+	Count   any    `json:"count,omitempty"`
+}
+
+// SUBSCRIBE, PSUBSCRIBE, SSUBSCRIBE and their UNSUBSCRIBE
+func isPubsubCmd(argv []string) bool {
+	cmd := strings.ToLower(argv[0])
+	if _, ok := unsubscribeCmds[cmd]; ok {
+		return true
+	}
+	_, ok := unsubscribeCmds[strings.TrimPrefix(cmd, "un")]
+	return ok
+}
+
+// subscribe command of an unsubscribe command, like "psubscribe" of "punsubscribe"
+func subscribeOf(cmd string) string {
+	for sub, unsub := range unsubscribeCmds {
+		if cmd == unsub {
+			return sub
+		}
+	}
+	return cmd
+}
+
+// run pub/sub command, then print messages until Ctrl-C if connection is subscribed
+func (c *Connection) ExecSubscribe(argv []string) error {
+	if c.cluster != nil {
+		defer c.cluster.active.Store(nil)
+	}
+	node, err := c.streamNode(argv)
+	if err != nil {
+		return err
+	}
+	// messages are streamed, so records of --ndjson have no elapsed time
+	node.lastCmd, node.lastElapsed = argv, 0
+	if err := node.pubsub(argv); err != nil {
+		return err
+	}
+	if _, ok := unsubscribeCmds[strings.ToLower(argv[0])]; !ok || !node.subscribed() {
+		return nil
+	}
+	line, err := node.readMessages()
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(line) != "" {
+		// the line typed to leave is run as if it's typed at the prompt
+		runLine(line)
+	}
+	return nil
+}
+
+// connection to stream replies of command from, in cluster mode it's the node routed to,
+// which stays current for later commands and is interrupted by Ctrl-C until caller clears active
+func (c *Connection) streamNode(argv []string) (*Connection, error) {
+	node := c
+	if c.cluster != nil {
+		var err error
+		if node, err = c.cluster.route(argv); err != nil {
+			return nil, err
+		}
+		c.cluster.current = node
+		c.cluster.active.Store(node)
+	}
+	if !node.connected && node.reconnect {
+		if err := node.Reconnect(); err != nil {
+			return nil, err
+		}
+	}
+	if !node.connected {
+		return nil, ErrNotConnected
+	}
+	node.lastActive = time.Now()
+	return node, nil
+}
+
+func (c *Connection) subscribed() bool {
+	for _, names := range c.subs {
+		if len(names) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// send (un)subscribe command and read a confirmation for each channel,
+// messages arriving meanwhile are printed as well
+func (c *Connection) pubsub(argv []string) error {
+	cmd := strings.ToLower(argv[0])
+	want := len(argv) - 1
+	if want == 0 {
+		// without channels, unsubscribe from all of them, or confirm once if there's none
+		want = max(len(c.subs[subscribeOf(cmd)]), 1)
+	}
+	if err := c.Send(argv); err != nil {
+		_ = c.Close()
+		return err
+	}
+	for want > 0 {
+		tv, err := c.ReceiveValue()
+		if err != nil {
+			_ = c.Close()
+			return err
+		}
+		c.printMessage(tv)
+		if tv.IsError() {
+			// the whole command is rejected
+			return nil
+		}
+		if c.confirmed(tv) {
+			want--
+		}
+	}
+	return nil
+}
+
+// keep track of subscriptions by confirmations, returns false if it's not a confirmation
+func (c *Connection) confirmed(tv *TypedVal) bool {
+	items := listOf(tv)
+	if len(items) != 3 {
+		return false
+	}
+	kind := strings.ToLower(items[0].String())
+	sub := subscribeOf(kind)
+	if _, ok := unsubscribeCmds[sub]; !ok {
+		return false
+	}
+	if c.subs == nil {
+		c.subs = map[string]map[string]bool{}
+	}
+	if c.subs[sub] == nil {
+		c.subs[sub] = map[string]bool{}
+	}
+	if kind == sub {
+		c.subs[sub][items[1].String()] = true
+	} else {
+		delete(c.subs[sub], items[1].String())
+	}
+	return true
+}
+
+// print messages until Ctrl-C, which unsubscribes from all channels,
+// under RESP3 Enter returns to the prompt and keeps the subscriptions, the line typed is returned
+func (c *Connection) readMessages() (string, error) {
+	detachable := c.resp == 3 && ttyState != nil && pollable(c.conn)
+	if !c.raw() && !c.jsonOutput() {
+		hint := "press Ctrl-C to quit"
+		if detachable {
+			hint += " or Enter to type commands"
+		}
+		_, _ = fmt.Fprintf(c.writer, "Reading messages... (%s)\n", hint)
+	}
+	for c.subscribed() {
+		if detachable {
+			ready, err := c.waitMessage()
+			if errors.Is(err, ErrInterrupted) {
+				return "", c.unsubscribeAll()
+			}
+			if err != nil {
+				return "", err
+			}
+			if !ready {
+				return readLine(os.Stdin)
+			}
+		}
+		tv, err := c.receive(0)
+		if errors.Is(err, ErrInterrupted) {
+			return "", c.unsubscribeAll()
+		}
+		if err != nil {
+			_ = c.Close()
+			return "", err
+		}
+		c.printMessage(tv)
+		c.confirmed(tv)
+	}
+	return "", nil
+}
+
+// read one line byte by byte, so nothing after it is taken from the prompt
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
+
+// wait until a message arrives, returns false if a line is typed first
+func (c *Connection) waitMessage() (bool, error) {
+	if c.bufReader.Buffered() > 0 {
+		return true, nil
+	}
+	c.interrupted.Store(false)
+	c.inflight.Store(true)
+	defer c.inflight.Store(false)
+	for !c.interrupted.Load() {
+		if !c.deadline.IsZero() && !time.Now().Before(c.deadline) {
+			return false, ErrDeadline
+		}
+		connReady, stdinReady := waitReadable(c.conn, 100*time.Millisecond)
+		if connReady {
+			return true, nil
+		}
+		if stdinReady {
+			return false, nil
+		}
+	}
+	return false, ErrInterrupted
+}
+
+// leave subscribe mode, channels are given by name so the number of confirmations is known
+func (c *Connection) unsubscribeAll() error {
+	for _, sub := range []string{"subscribe", "psubscribe", "ssubscribe"} {
+		names := make([]string, 0, len(c.subs[sub]))
+		for name := range c.subs[sub] {
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			continue
+		}
+		sort.Strings(names)
+		if err := c.pubsub(append([]string{unsubscribeCmds[sub]}, names...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// print message like redis-cli: 1) "message" 2) "channel" 3) "payload", or a JSON line with --json
+func (c *Connection) printMessage(tv *TypedVal) {
+	if c.jsonOutput() && !tv.IsError() {
+		c.printJsonLine(encodeJson(pubsubJson(tv, c.args.QuotedJson)))
+		return
+	}
+	c.PrintVal(tv)
+}
+
+func pubsubJson(tv *TypedVal, quoted bool) any {
+	items := listOf(tv)
+	if len(items) == 0 {
+		return formatJson(tv, quoted)
+	}
+	event := pubsubEvent{Type: items[0].String()}
+	switch {
+	case event.Type == "pmessage" && len(items) == 4:
+		event.Pattern, event.Channel, event.Payload = formatJson(items[1], quoted), formatJson(items[2], quoted), formatJson(items[3], quoted)
+	case (event.Type == "message" || event.Type == "smessage") && len(items) == 3:
+		event.Channel, event.Payload = formatJson(items[1], quoted), formatJson(items[2], quoted)
+	case len(items) == 3 && subscribeOf(event.Type) == "psubscribe":
+		event.Pattern, event.Count = formatJson(items[1], quoted), formatJson(items[2], quoted)
+	case len(items) == 3 && strings.HasSuffix(event.Type, "subscribe"):
+		event.Channel, event.Count = formatJson(items[1], quoted), formatJson(items[2], quoted)
+	default:
+		return formatJson(tv, quoted)
+	}
+	return event
+}
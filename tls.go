@@ -0,0 +1,407 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+func (c *Connection) parseTlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.args.Sni,
+		InsecureSkipVerify: c.args.Insecure,
+	}
+
+	if c.args.Cert != "" {
+		// private key may be bundled in the same pem file with certificate
+		cert, err := tls.LoadX509KeyPair(c.args.Cert, defaults(c.args.Key, c.args.Cert))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	// if neither cacert nor cacertdir are specified, RootCAs stays nil
+	// and the system-wide trusted root certs will be used
+	if c.args.Cacert != "" || c.args.Cacertdir != "" {
+		config.RootCAs = x509.NewCertPool()
+	}
+
+	if c.args.Cacert != "" {
+		if err := loadCACertFile(config.RootCAs, c.args.Cacert); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.args.Cacertdir != "" {
+		if err := loadCACertificates(config.RootCAs, c.args.Cacertdir); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.args.TlsCiphers != "" {
+		ciphers, err := parseCiphers(c.args.TlsCiphers)
+		if err != nil {
+			return nil, err
+		}
+		config.CipherSuites = ciphers
+	}
+
+	if c.args.TlsCiphersuites != "" {
+		// go doesn't allow to configure TLSv1.3 ciphersuites, all of them are always enabled,
+		// so names are only validated here
+		if _, err := parseCipherSuites(c.args.TlsCiphersuites); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// load CA bundle, a pem file may contain multiple certificates
+func loadCACertFile(pool *x509.CertPool, file string) error {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("failed to load CA certificate: no certificate found in %s", file)
+	}
+	return nil
+}
+
+// load all pem files in dir, files without certificate are skipped,
+// but at least one certificate must be found
+func loadCACertificates(pool *x509.CertPool, dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to load CA certificates: %w", err)
+	}
+
+	loaded := 0
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		pem, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to load CA certificates: %w", err)
+		}
+		// like openssl, other files in the dir, such as README or CRLs, don't matter
+		if pool.AppendCertsFromPEM(pem) {
+			loaded++
+		}
+	}
+	if loaded == 0 {
+		return fmt.Errorf("failed to load CA certificates: no certificate found in %s", dir)
+	}
+	return nil
+}
+
+// openssl cipher names (TLSv1.2 and below) supported by go
+var opensslCiphers = map[string]uint16{
+	"RC4-SHA":                       tls.TLS_RSA_WITH_RC4_128_SHA,
+	"DES-CBC3-SHA":                  tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	"AES128-SHA":                    tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"AES256-SHA":                    tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"AES128-SHA256":                 tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"AES128-GCM-SHA256":             tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"AES256-GCM-SHA384":             tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"ECDHE-ECDSA-RC4-SHA":           tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+	"ECDHE-ECDSA-AES128-SHA":        tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"ECDHE-ECDSA-AES256-SHA":        tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"ECDHE-RSA-RC4-SHA":             tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
+	"ECDHE-RSA-DES-CBC3-SHA":        tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	"ECDHE-RSA-AES128-SHA":          tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"ECDHE-RSA-AES256-SHA":          tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"ECDHE-ECDSA-AES128-SHA256":     tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"ECDHE-RSA-AES128-SHA256":       tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"ECDHE-RSA-AES128-GCM-SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"ECDHE-ECDSA-AES128-GCM-SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"ECDHE-RSA-AES256-GCM-SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"ECDHE-ECDSA-AES256-GCM-SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"ECDHE-RSA-CHACHA20-POLY1305":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	"ECDHE-ECDSA-CHACHA20-POLY1305": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// openssl cipher names (TLSv1.2 and below) not supported by go, they enable nothing,
+// so common cipher strings listing them along with supported ones still work
+var opensslUnsupportedCiphers = map[string]bool{
+	"ECDHE-RSA-AES256-SHA384": true, "ECDHE-ECDSA-AES256-SHA384": true, "AES256-SHA256": true,
+	"ECDHE-ECDSA-DES-CBC3-SHA": true, "RC4-MD5": true, "NULL-MD5": true, "NULL-SHA": true, "NULL-SHA256": true,
+	"ECDHE-RSA-NULL-SHA": true, "ECDHE-ECDSA-NULL-SHA": true,
+}
+
+// prefixes and parts of names of openssl cipher families not supported by go,
+// like DHE-RSA-AES128-GCM-SHA256 or ECDHE-ECDSA-CAMELLIA256-SHA384
+var (
+	opensslUnsupportedPrefixes = []string{"DHE-", "EDH-", "ADH-", "AECDH-", "DH-", "ECDH-", "EXP-", "PSK-", "RSA-PSK-", "ECDHE-PSK-", "SRP-"}
+	opensslUnsupportedParts    = []string{"CAMELLIA", "ARIA", "SEED", "IDEA", "-CCM", "DES-CBC-"}
+)
+
+func unsupportedOpensslCipher(name string) bool {
+	if opensslUnsupportedCiphers[name] {
+		return true
+	}
+	for _, prefix := range opensslUnsupportedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	for _, part := range opensslUnsupportedParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// keywords of openssl cipher strings which match no cipher supported by go
+var opensslNoCiphers = map[string]bool{
+	"aNULL": true, "eNULL": true, "NULL": true, "COMPLEMENTOFALL": true, "LOW": true, "EXP": true, "EXPORT": true,
+	"kDHE": true, "kEDH": true, "DH": true, "DHE": true, "EDH": true, "ADH": true, "AECDH": true, "aDSS": true, "DSS": true,
+	"PSK": true, "kPSK": true, "aPSK": true, "SRP": true, "kSRP": true, "MD5": true, "IDEA": true, "SEED": true,
+	"CAMELLIA": true, "CAMELLIA128": true, "CAMELLIA256": true, "ARIA": true, "ARIAGCM": true, "AESCCM": true, "AESCCM8": true,
+}
+
+// keywords of openssl cipher strings a cipher belongs to, derived from its name like "ECDHE-RSA-AES128-GCM-SHA256"
+func opensslKeywords(name string) map[string]bool {
+	kw := map[string]bool{"ALL": true}
+	if strings.HasPrefix(name, "ECDHE-") {
+		kw["kECDHE"], kw["kEECDH"], kw["ECDHE"], kw["EECDH"] = true, true, true, true
+	} else {
+		kw["kRSA"], kw["RSA"] = true, true
+	}
+	if strings.HasPrefix(name, "ECDHE-ECDSA-") {
+		kw["aECDSA"], kw["ECDSA"] = true, true
+	} else {
+		kw["aRSA"] = true
+	}
+	switch {
+	case strings.Contains(name, "AES128"):
+		kw["AES"], kw["AES128"], kw["HIGH"] = true, true, true
+	case strings.Contains(name, "AES256"):
+		kw["AES"], kw["AES256"], kw["HIGH"] = true, true, true
+	case strings.Contains(name, "CHACHA20"):
+		kw["CHACHA20"], kw["HIGH"] = true, true
+	case strings.Contains(name, "DES-CBC3"):
+		kw["3DES"], kw["MEDIUM"] = true, true
+	case strings.Contains(name, "RC4"):
+		kw["RC4"], kw["MEDIUM"] = true, true
+	}
+	switch {
+	case strings.Contains(name, "GCM"):
+		kw["AESGCM"] = true
+	case strings.HasSuffix(name, "-SHA"):
+		kw["SHA1"], kw["SHA"] = true, true
+	case strings.HasSuffix(name, "-SHA256"):
+		kw["SHA256"] = true
+	case strings.HasSuffix(name, "-SHA384"):
+		kw["SHA384"] = true
+	}
+	if kw["AESGCM"] || kw["CHACHA20"] || kw["SHA256"] || kw["SHA384"] {
+		kw["TLSv1.2"] = true
+	} else {
+		kw["TLSv1.0"], kw["TLSv1"], kw["SSLv3"] = true, true, true
+	}
+	// RC4 is not enabled by default since openssl 1.1.0
+	if kw["RC4"] {
+		kw["COMPLEMENTOFDEFAULT"] = true
+	} else {
+		kw["DEFAULT"] = true
+	}
+	return kw
+}
+
+// key length of cipher for @STRENGTH
+func cipherStrength(name string) int {
+	kw := opensslKeywords(name)
+	switch {
+	case kw["AES256"] || kw["CHACHA20"]:
+		return 256
+	case kw["3DES"]:
+		return 112
+	default:
+		return 128
+	}
+}
+
+// ciphers matched by one element of cipher string, a name, a keyword, or keywords joined by "+" like "ECDHE+AESGCM"
+func matchCiphers(names []string, elem string) ([]string, error) {
+	if _, ok := opensslCiphers[elem]; ok {
+		return []string{elem}, nil
+	}
+	if id, ok := ianaCipherID(elem); ok {
+		if isTls13Cipher(id) {
+			return nil, fmt.Errorf("'%s' is a TLSv1.3 ciphersuite, use --tls-ciphersuites instead", elem)
+		}
+		for name, nid := range opensslCiphers {
+			if nid == id {
+				return []string{name}, nil
+			}
+		}
+	}
+	if unsupportedOpensslCipher(elem) {
+		return nil, nil
+	}
+	parts := strings.Split(elem, "+")
+	known := map[string]bool{}
+	for _, name := range names {
+		for kw := range opensslKeywords(name) {
+			known[kw] = true
+		}
+	}
+	for _, part := range parts {
+		if !known[part] && !opensslNoCiphers[part] {
+			return nil, fmt.Errorf("unknown TLS cipher or keyword '%s' in --tls-ciphers", part)
+		}
+	}
+	var res []string
+	for _, name := range names {
+		kw := opensslKeywords(name)
+		all := true
+		for _, part := range parts {
+			all = all && kw[part]
+		}
+		if all {
+			res = append(res, name)
+		}
+	}
+	return res, nil
+}
+
+// parse openssl cipher string, like "ECDHE-RSA-AES128-GCM-SHA256:ECDHE-RSA-AES256-GCM-SHA384" or "HIGH:!aNULL:!RC4",
+// elements are cipher names (IANA names are also accepted) or keywords, and keywords joined by "+" match ciphers having all of them,
+// names of openssl ciphers go doesn't support are skipped, only unknown names are errors,
+// prefix "!" removes ciphers for good, "-" removes them but they may be added again, "+" moves them to the end,
+// and @STRENGTH sorts by key length, @SECLEVEL is ignored
+func parseCiphers(ciphers string) ([]uint16, error) {
+	names := make([]string, 0, len(opensslCiphers))
+	for name := range opensslCiphers {
+		names = append(names, name)
+	}
+	// stable order for keywords matching many ciphers, strongest first like openssl
+	sort.Slice(names, func(i, j int) bool {
+		si, sj := cipherStrength(names[i]), cipherStrength(names[j])
+		if si != sj {
+			return si > sj
+		}
+		return names[i] < names[j]
+	})
+
+	var list []string
+	killed := map[string]bool{}
+	remove := func(matched []string) {
+		drop := map[string]bool{}
+		for _, name := range matched {
+			drop[name] = true
+		}
+		kept := list[:0]
+		for _, name := range list {
+			if !drop[name] {
+				kept = append(kept, name)
+			}
+		}
+		list = kept
+	}
+	for _, elem := range splitCipherList(ciphers) {
+		switch {
+		case elem == "@STRENGTH":
+			sort.SliceStable(list, func(i, j int) bool {
+				return cipherStrength(list[i]) > cipherStrength(list[j])
+			})
+			continue
+		case strings.HasPrefix(elem, "@SECLEVEL="):
+			continue
+		}
+		op := elem[0]
+		if op == '!' || op == '-' || op == '+' {
+			elem = elem[1:]
+			if elem == "" {
+				return nil, fmt.Errorf("missing cipher or keyword after '%c' in --tls-ciphers", op)
+			}
+		}
+		matched, err := matchCiphers(names, elem)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case '!':
+			for _, name := range matched {
+				killed[name] = true
+			}
+			remove(matched)
+		case '-':
+			remove(matched)
+		case '+':
+			active := map[string]bool{}
+			for _, name := range list {
+				active[name] = true
+			}
+			var moved []string
+			for _, name := range matched {
+				if active[name] {
+					moved = append(moved, name)
+				}
+			}
+			remove(moved)
+			list = append(list, moved...)
+		default:
+			for _, name := range matched {
+				if !killed[name] && !slices.Contains(list, name) {
+					list = append(list, name)
+				}
+			}
+		}
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no cipher enabled by --tls-ciphers '%s'", ciphers)
+	}
+	res := make([]uint16, len(list))
+	for i, name := range list {
+		res[i] = opensslCiphers[name]
+	}
+	return res, nil
+}
+
+// parse TLSv1.3 ciphersuites, like "TLS_AES_256_GCM_SHA384:TLS_CHACHA20_POLY1305_SHA256"
+func parseCipherSuites(ciphersuites string) ([]uint16, error) {
+	var res []uint16
+	for _, name := range splitCipherList(ciphersuites) {
+		id, ok := ianaCipherID(name)
+		if !ok || !isTls13Cipher(id) {
+			return nil, fmt.Errorf("unknown TLSv1.3 ciphersuite '%s' in --tls-ciphersuites", name)
+		}
+		res = append(res, id)
+	}
+	return res, nil
+}
+
+func splitCipherList(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ':' || r == ',' || r == ' '
+	})
+}
+
+func ianaCipherID(name string) (uint16, bool) {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, suite := range suites {
+			if suite.Name == name {
+				return suite.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func isTls13Cipher(id uint16) bool {
+	switch id {
+	case tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384, tls.TLS_CHACHA20_POLY1305_SHA256:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCiphers(t *testing.T) {
+	tests := []struct {
+		ciphers string
+		want    []uint16
+		err     string
+	}{
+		{
+			ciphers: "ECDHE-RSA-AES128-GCM-SHA256:ECDHE-RSA-AES256-GCM-SHA384",
+			want:    []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+		},
+		{
+			// IANA names are accepted too
+			ciphers: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+			want:    []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			// names go doesn't support are skipped
+			ciphers: "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES128-GCM-SHA256:ECDHE-RSA-AES256-SHA384",
+			want:    []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			ciphers: "ECDHE+AESGCM+aRSA",
+			want:    []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			// killed ciphers are not added again, removed ones are
+			ciphers: "!AES256:ECDHE+AESGCM+aRSA:-AES128:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-RSA-AES256-GCM-SHA384",
+			want:    []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			// + moves matched ciphers to the end
+			ciphers: "ECDHE-RSA-AES128-GCM-SHA256:ECDHE-RSA-CHACHA20-POLY1305:ECDHE-RSA-AES256-GCM-SHA384:+AES128",
+			want: []uint16{tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			ciphers: "ECDHE-RSA-AES128-GCM-SHA256:ECDHE-RSA-AES256-GCM-SHA384:@STRENGTH:@SECLEVEL=2",
+			want:    []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			ciphers: "HIGH:!aNULL:!MD5:!RC4:!3DES:!SHA1:!kRSA:!ECDSA:!CHACHA20:!AES128",
+			want:    []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+		},
+		{ciphers: "BOGUS-CIPHER", err: "unknown TLS cipher or keyword 'BOGUS-CIPHER'"},
+		{ciphers: "HIGH:!", err: "missing cipher or keyword after '!'"},
+		{ciphers: "aNULL", err: "no cipher enabled"},
+		{ciphers: "DHE-RSA-AES128-GCM-SHA256", err: "no cipher enabled"},
+		{ciphers: "TLS_AES_128_GCM_SHA256", err: "use --tls-ciphersuites"},
+	}
+	for _, tt := range tests {
+		got, err := parseCiphers(tt.ciphers)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseCiphers(%q) error = %v, want %q", tt.ciphers, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCiphers(%q) unexpected error: %v", tt.ciphers, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCiphers(%q) = %x, want %x", tt.ciphers, got, tt.want)
+		}
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	tests := []struct {
+		suites string
+		want   []uint16
+		err    bool
+	}{
+		{suites: "TLS_AES_256_GCM_SHA384", want: []uint16{tls.TLS_AES_256_GCM_SHA384}},
+		{suites: "TLS_AES_128_GCM_SHA256:TLS_CHACHA20_POLY1305_SHA256", want: []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_CHACHA20_POLY1305_SHA256}},
+		{suites: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", err: true},
+		{suites: "TLS_AES_128_CCM_SHA256", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseCipherSuites(tt.suites)
+		if (err != nil) != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCipherSuites(%q) = %x, %v, want %x, error %v", tt.suites, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+// self signed CA certificate in PEM
+func testCACert(t *testing.T, name string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestLoadCACertificates(t *testing.T) {
+	writeFiles := func(files map[string][]byte) string {
+		dir := t.TempDir()
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), content, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+	ca1, ca2 := testCACert(t, "ca1"), testCACert(t, "ca2")
+
+	bundle := filepath.Join(writeFiles(map[string][]byte{"bundle.pem": append(ca1, ca2...)}), "bundle.pem")
+	pool := x509.NewCertPool()
+	if err := loadCACertFile(pool, bundle); err != nil {
+		t.Errorf("loadCACertFile(bundle) unexpected error: %v", err)
+	} else if n := len(pool.Subjects()); n != 2 {
+		t.Errorf("loadCACertFile(bundle) loaded %d certificates, want 2", n)
+	}
+	notPem := filepath.Join(writeFiles(map[string][]byte{"key.txt": []byte("not a certificate")}), "key.txt")
+	if err := loadCACertFile(x509.NewCertPool(), notPem); err == nil {
+		t.Errorf("loadCACertFile(not pem) want error")
+	}
+	if err := loadCACertFile(x509.NewCertPool(), filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Errorf("loadCACertFile(missing) want error")
+	}
+
+	tests := []struct {
+		name  string
+		dir   string
+		count int
+		err   bool
+	}{
+		{name: "certificates", dir: writeFiles(map[string][]byte{"a.pem": ca1, "b.0": ca2}), count: 2},
+		{name: "other files skipped", dir: writeFiles(map[string][]byte{"a.pem": ca1, "README": []byte("hashed certs")}), count: 1},
+		{name: "no certificate", dir: writeFiles(map[string][]byte{"README": []byte("hashed certs")}), err: true},
+		{name: "empty", dir: t.TempDir(), err: true},
+		{name: "missing", dir: filepath.Join(t.TempDir(), "missing"), err: true},
+	}
+	for _, tt := range tests {
+		pool := x509.NewCertPool()
+		err := loadCACertificates(pool, tt.dir)
+		if (err != nil) != tt.err {
+			t.Errorf("loadCACertificates(%s) error = %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if n := len(pool.Subjects()); !tt.err && n != tt.count {
+			t.Errorf("loadCACertificates(%s) loaded %d certificates, want %d", tt.name, n, tt.count)
+		}
+	}
+}
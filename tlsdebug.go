@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// print negotiated TLS session and peer certificate chain of current connection
+func (c *Connection) PrintTlsInfo() {
+	if c.cluster != nil {
+		c.cluster.current.PrintTlsInfo()
+		return
+	}
+	tlsConn, ok := c.conn.(*tls.Conn)
+	if !c.connected || !ok {
+		c.PrintRawString("not connected with TLS\n")
+		return
+	}
+	printTlsReport(c.writer, tlsConn.ConnectionState(), c.tlsConfig, c.serverName())
+}
+
+// when handshake fails, connect again without verification,
+// so we can still show what the server presents and why it's rejected,
+// dialer of the failed connection is used, so it's bound by --connect-timeout and --deadline too
+func (c *Connection) probeTls(dialer *net.Dialer, network, addr string, conf *tls.Config) {
+	probeConf := conf.Clone()
+	probeConf.InsecureSkipVerify = true
+	conn, err := tls.DialWithDialer(dialer, network, addr, probeConf)
+	if err != nil {
+		_, _ = fmt.Fprintf(c.writer, "TLS handshake failed: %s\n", err.Error())
+		return
+	}
+	defer conn.Close()
+	printTlsReport(c.writer, conn.ConnectionState(), conf, c.serverName())
+}
+
+// name used for SNI and hostname verification, -h is used over unix socket too, like dial does
+func (c *Connection) serverName() string {
+	if c.args.Sni != "" {
+		return c.args.Sni
+	}
+	return c.host
+}
+
+func printTlsReport(w io.Writer, state tls.ConnectionState, conf *tls.Config, serverName string) {
+	alpn := defaults(state.NegotiatedProtocol, "(none)")
+	sni := serverName
+	if sni == "" {
+		sni = "(none)"
+	} else if net.ParseIP(sni) != nil {
+		// go never sends ip address as SNI
+		sni = fmt.Sprintf("(none, verify against IP %s)", sni)
+	}
+	_, _ = fmt.Fprintf(w, "TLS session:\n")
+	_, _ = fmt.Fprintf(w, "  version:      %s\n", tls.VersionName(state.Version))
+	_, _ = fmt.Fprintf(w, "  cipher suite: %s\n", tls.CipherSuiteName(state.CipherSuite))
+	_, _ = fmt.Fprintf(w, "  ALPN:         %s\n", alpn)
+	_, _ = fmt.Fprintf(w, "  SNI:          %s\n", sni)
+	_, _ = fmt.Fprintf(w, "Certificate chain:\n")
+	now := time.Now()
+	for i, cert := range state.PeerCertificates {
+		_, _ = fmt.Fprintf(w, "  %d subject: %s\n", i, cert.Subject)
+		if sans := certSANs(cert); len(sans) > 0 {
+			_, _ = fmt.Fprintf(w, "    SANs:    %s\n", strings.Join(sans, ", "))
+		}
+		_, _ = fmt.Fprintf(w, "    issuer:  %s\n", cert.Issuer)
+		_, _ = fmt.Fprintf(w, "    valid:   %s to %s (%s)\n",
+			cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339), expiryDesc(cert, now))
+	}
+	_, _ = fmt.Fprintf(w, "Verification: %s\n", verifyVerdict(state.PeerCertificates, conf, serverName))
+}
+
+func certSANs(cert *x509.Certificate) []string {
+	var sans []string
+	for _, name := range cert.DNSNames {
+		sans = append(sans, "DNS:"+name)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+	return sans
+}
+
+func expiryDesc(cert *x509.Certificate, now time.Time) string {
+	switch {
+	case now.Before(cert.NotBefore):
+		return "not yet valid"
+	case now.After(cert.NotAfter):
+		return fmt.Sprintf("expired %d days ago", int(now.Sub(cert.NotAfter).Hours()/24))
+	default:
+		return fmt.Sprintf("expires in %d days", int(cert.NotAfter.Sub(now).Hours()/24))
+	}
+}
+
+// verify peer chain the same way as the handshake does, and explain the failure
+func verifyVerdict(certs []*x509.Certificate, conf *tls.Config, serverName string) string {
+	if len(certs) == 0 {
+		return "FAILED, server sent no certificate"
+	}
+	opts := x509.VerifyOptions{
+		Roots:         conf.RootCAs,
+		Intermediates: x509.NewCertPool(),
+		DNSName:       serverName,
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	skipped := ""
+	if conf.InsecureSkipVerify {
+		skipped = " (ignored because of --insecure)"
+	}
+	if err == nil {
+		if serverName == "" {
+			return "OK, chain is trusted, hostname not checked" + skipped
+		}
+		return "OK" + skipped
+	}
+
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var reason string
+	switch {
+	case errors.As(err, &unknownAuthority):
+		issuer := certs[len(certs)-1].Issuer
+		reason = fmt.Sprintf("unknown CA '%s', use --cacert or --cacertdir to trust it", issuer)
+	case errors.As(err, &hostnameErr):
+		reason = fmt.Sprintf("hostname mismatch, %s, use --sni to set the expected name", hostnameErr.Error())
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		reason = fmt.Sprintf("certificate '%s' is expired or not yet valid, %s", invalidErr.Cert.Subject, invalidErr.Detail)
+	default:
+		reason = err.Error()
+	}
+	return "FAILED, " + reason + skipped
+}
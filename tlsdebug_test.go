@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerName(t *testing.T) {
+	tests := []struct {
+		sni    string
+		host   string
+		socket string
+		want   string
+	}{
+		{host: "redis.example.com", want: "redis.example.com"},
+		{host: "10.0.0.1", want: "10.0.0.1"},
+		{sni: "cache.internal", host: "10.0.0.1", want: "cache.internal"},
+		// over unix socket the certificate is verified against -h, unless --sni is given
+		{host: "localhost", socket: "/tmp/redis.sock", want: "localhost"},
+		{sni: "cache.internal", host: "localhost", socket: "/tmp/redis.sock", want: "cache.internal"},
+	}
+	for _, tt := range tests {
+		c := &Connection{args: &Args{Sni: tt.sni}, host: tt.host, socket: tt.socket}
+		if got := c.serverName(); got != tt.want {
+			t.Errorf("serverName(--sni %q, -h %q, -s %q) = %q, want %q", tt.sni, tt.host, tt.socket, got, tt.want)
+		}
+	}
+}
+
+func TestProbeTlsTimeout(t *testing.T) {
+	// server accepts but never answers the handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	var buf bytes.Buffer
+	c := &Connection{args: &Args{}, writer: &buf}
+	start := time.Now()
+	c.probeTls(&net.Dialer{Timeout: 200 * time.Millisecond}, "tcp", ln.Addr().String(), &tls.Config{})
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("probeTls took %v, want it bound by dialer timeout", elapsed)
+	}
+	if !strings.HasPrefix(buf.String(), "TLS handshake failed: ") {
+		t.Errorf("probeTls output = %q, want handshake failure", buf.String())
+	}
+}
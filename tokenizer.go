@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+)
+
+var ErrInvalidArgs = errors.New("Invalid argument(s)")
+
+// split a command line into arguments, compatible with redis-cli (sdssplitargs)
+//
+//	foo bar "newline are supported\n" and "\xff\x00otherstuff"
+//
+// double quoted strings support \n \r \t \b \a and \xNN escapes,
+// single quoted strings only support \' , and a closing quote must be
+// followed by a space or the end of line, otherwise ErrInvalidArgs is returned
+func SplitArgs(line string) ([]string, error) {
+	var res []string
+	p := 0
+	for {
+		// skip blanks
+		for p < len(line) && isSpace(line[p]) {
+			p++
+		}
+		if p >= len(line) {
+			return res, nil
+		}
+		var (
+			inq     bool // inside "double quotes"
+			insq    bool // inside 'single quotes'
+			done    bool
+			current []byte
+		)
+		// empty string "" is a valid argument, so we can't rely on len(current)
+		current = []byte{}
+		for !done {
+			if inq {
+				if p >= len(line) {
+					// unterminated quotes
+					return nil, ErrInvalidArgs
+				}
+				c := line[p]
+				if c == '\\' && p+1 < len(line) {
+					c, p = decodeEscape(line, p)
+					current = append(current, c)
+				} else if c == '"' {
+					// closing quote must be followed by a space or nothing at all
+					if p+1 < len(line) && !isSpace(line[p+1]) {
+						return nil, ErrInvalidArgs
+					}
+					done = true
+				} else {
+					current = append(current, c)
+				}
+			} else if insq {
+				if p >= len(line) {
+					// unterminated quotes
+					return nil, ErrInvalidArgs
+				}
+				c := line[p]
+				if c == '\\' && p+1 < len(line) && line[p+1] == '\'' {
+					p++
+					current = append(current, '\'')
+				} else if c == '\'' {
+					// closing quote must be followed by a space or nothing at all
+					if p+1 < len(line) && !isSpace(line[p+1]) {
+						return nil, ErrInvalidArgs
+					}
+					done = true
+				} else {
+					current = append(current, c)
+				}
+			} else {
+				if p >= len(line) {
+					break
+				}
+				switch c := line[p]; c {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inq = true
+				case '\'':
+					insq = true
+				default:
+					current = append(current, c)
+				}
+			}
+			if p < len(line) {
+				p++
+			}
+		}
+		res = append(res, string(current))
+	}
+}
+
+// decode escape sequence starting with backslash at line[p]: \xNN, \n \r \t \b \a,
+// or any other char as is, returns the byte and position of the last char of the sequence
+func decodeEscape(line string, p int) (byte, int) {
+	if p+3 < len(line) && line[p+1] == 'x' && isHexDigit(line[p+2]) && isHexDigit(line[p+3]) {
+		return hexDigitToInt(line[p+2])*16 + hexDigitToInt(line[p+3]), p + 3
+	}
+	p++
+	switch line[p] {
+	case 'n':
+		return '\n', p
+	case 'r':
+		return '\r', p
+	case 't':
+		return '\t', p
+	case 'b':
+		return '\b', p
+	case 'a':
+		return '\a', p
+	default:
+		return line[p], p
+	}
+}
+
+// decode escape sequences like in double quoted strings, used by -d and -D
+func Unescape(str string) string {
+	res := make([]byte, 0, len(str))
+	for p := 0; p < len(str); p++ {
+		c := str[p]
+		if c == '\\' && p+1 < len(str) {
+			c, p = decodeEscape(str, p)
+		}
+		res = append(res, c)
+	}
+	return string(res)
+}
+
+// unquote a single argument, used by --quoted-input
+func UnquoteArg(arg string) (string, error) {
+	a, err := SplitArgs(arg)
+	if err != nil || len(a) != 1 {
+		return "", errors.New("Invalid quoted string")
+	}
+	return a[0], nil
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexDigitToInt(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
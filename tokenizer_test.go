@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+		err  bool
+	}{
+		{line: "", want: nil},
+		{line: "   ", want: nil},
+		{line: "get foo", want: []string{"get", "foo"}},
+		{line: "  set\tfoo   bar  ", want: []string{"set", "foo", "bar"}},
+		{line: `set k ""`, want: []string{"set", "k", ""}},
+		{line: `set k "hello world"`, want: []string{"set", "k", "hello world"}},
+		{line: `set k "a\nb\r\t\b\a"`, want: []string{"set", "k", "a\nb\r\t\b\a"}},
+		{line: `set k "\xff\x00x"`, want: []string{"set", "k", "\xff\x00x"}},
+		{line: `set k "\xzz"`, want: []string{"set", "k", "xzz"}},
+		{line: `set k "say \"hi\""`, want: []string{"set", "k", `say "hi"`}},
+		{line: `set k 'it\'s'`, want: []string{"set", "k", "it's"}},
+		{line: `set k 'a\nb'`, want: []string{"set", "k", `a\nb`}},
+		{line: `set k a"b c"`, want: []string{"set", "k", "ab c"}},
+		{line: `set k "unterminated`, err: true},
+		{line: `set k 'unterminated`, err: true},
+		{line: `set k "a"b`, err: true},
+		{line: `set k 'a'b`, err: true},
+	}
+	for _, tt := range tests {
+		got, err := SplitArgs(tt.line)
+		if tt.err {
+			if err != ErrInvalidArgs {
+				t.Errorf("SplitArgs(%q) error = %v, want ErrInvalidArgs", tt.line, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("SplitArgs(%q) unexpected error: %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestUnquoteArg(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+		err  bool
+	}{
+		{arg: "foo", want: "foo"},
+		{arg: `"a\x00b"`, want: "a\x00b"},
+		{arg: `'single'`, want: "single"},
+		{arg: "two words", err: true},
+		{arg: "", err: true},
+		{arg: `"open`, err: true},
+	}
+	for _, tt := range tests {
+		got, err := UnquoteArg(tt.arg)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("UnquoteArg(%q) = %q, %v, want %q, error %v", tt.arg, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		str  string
+		want string
+	}{
+		{str: "", want: ""},
+		{str: `\n`, want: "\n"},
+		{str: `\r\n`, want: "\r\n"},
+		{str: `,\t;`, want: ",\t;"},
+		{str: `\x00\xFF`, want: "\x00\xff"},
+		{str: `\x0`, want: "x0"},
+		{str: `\\`, want: `\`},
+		{str: `\q`, want: "q"},
+		{str: `trailing\`, want: `trailing\`},
+		{str: "plain", want: "plain"},
+	}
+	for _, tt := range tests {
+		if got := Unescape(tt.str); got != tt.want {
+			t.Errorf("Unescape(%q) = %q, want %q", tt.str, got, tt.want)
+		}
+	}
+}
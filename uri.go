@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// connection info parsed from -u <uri>, empty fields are not specified in uri
+//
+//	redis://[[username]:[password]@][host][:port][/db-number]
+//	rediss://... (same as redis://, with TLS enabled)
+//	unix://[[username]:[password]@]/path/to/redis.sock[?db=db-number]
+type redisUri struct {
+	Tls      bool
+	Hostname string
+	Port     string
+	Socket   string
+	User     string
+	Password string
+	Db       string
+}
+
+func parseRedisUri(uri string) (*redisUri, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	res := &redisUri{}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		res.Tls = true
+	case "unix":
+		if u.Path == "" {
+			return nil, errors.New("Invalid URI socket path")
+		}
+		res.Socket = u.Path
+		res.Db = u.Query().Get("db")
+	default:
+		return nil, errors.New("Invalid URI scheme")
+	}
+
+	// same as redis-cli, "redis://secret@host" means password only
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			res.User = u.User.Username()
+			res.Password = pass
+		} else {
+			res.Password = u.User.Username()
+		}
+	}
+
+	if res.Socket == "" {
+		// Hostname() strips brackets of IPv6 literal, like [::1]
+		res.Hostname = u.Hostname()
+		res.Port = u.Port()
+		if res.Port != "" {
+			if _, err := strconv.Atoi(res.Port); err != nil {
+				return nil, errors.New("Invalid URI port")
+			}
+		}
+		res.Db = strings.TrimPrefix(u.Path, "/")
+	}
+	if res.Db != "" {
+		if _, err := strconv.Atoi(res.Db); err != nil {
+			return nil, errors.New("Invalid URI db number")
+		}
+	}
+	return res, nil
+}
+
+// fill args with values from uri, like they are specified at the position of -u,
+// so options after -u override the uri, and options before are overridden by it
+func applyUri(fieldsMap map[string]*fv, uri string) {
+	u, err := parseRedisUri(uri)
+	if err != nil {
+		fmt.Printf("%s: %s\n", err.Error(), uri)
+		os.Exit(1)
+	}
+	set := func(flag, val string) {
+		if val != "" {
+			fieldsMap[flag].SetValue(val)
+		}
+	}
+	if u.Tls {
+		fieldsMap["tls"].SetValue("")
+	}
+	set("h", u.Hostname)
+	set("p", u.Port)
+	set("s", u.Socket)
+	set("user", u.User)
+	if u.Password != "" {
+		// --pass takes precedence over -a, clear it so the uri wins
+		fieldsMap["pass"].SetValue("")
+		set("a", u.Password)
+	}
+	set("n", u.Db)
+}